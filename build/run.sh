@@ -30,12 +30,14 @@ PORT="8080"
 ROOTPATH="html"
 PIDPATH="/var/run/sysadm.pid"
 INDEXS="index.html index.htm"
+PIDDIRMODE="0755"
 
 #For logger
 LOGLEVEL="debug"
 ACCESSLOG="logs/sysadm-access.log"
 ERRORLOG="logs/sysadm-error.log"
 LOGTYPE="text"
+LOGDIRMODE="0755"
 
 PHONY="all server install clean"
 
@@ -103,6 +105,7 @@ var defaultServerSettings = server{
     RootPath: "${ROOTPATH}",
     PidPath:  "${PIDPATH}",
     Indexs:   "${INDEXS}",
+    DirMode:  "${PIDDIRMODE}",
 }
 
 var defaultLoggerSettings = logger{
@@ -110,6 +113,7 @@ var defaultLoggerSettings = logger{
     AccessLog: "${ACCESSLOG}",
     ErrorLog:  "${ERRORLOG}",
     Logtype:   "$LOGTYPE",
+    DirMode:   "${LOGDIRMODE}",
 }
 
 EOF
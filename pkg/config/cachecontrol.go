@@ -0,0 +1,182 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified May 02 2021
+**/
+
+package config
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+* Struct for a rule mapping paths to the Cache-Control directives of the responses. Expires is
+* computed from the directives. The first matching rule in the list is applied
+ */
+type CacheRule struct {
+	//a glob such as *.css or /assets/**, or an extension such as .html. The glob without slash matches the base name.
+	//** matches any number of directories, and /assets/* at the end matches the nested paths under /assets too
+	Match      string `yaml:"match"`
+	Directives string `yaml:"directives"` //the value of Cache-Control, such as "max-age=31536000, immutable" or "no-cache"
+
+	directives map[string]string //Directives parsed by checkCacheRules
+}
+
+//The directives of Cache-Control in responses defined by RFC 7234 and RFC 8246. The value is true if it takes seconds
+var cacheDirectives = map[string]bool{
+	"max-age":                true,
+	"s-maxage":               true,
+	"stale-while-revalidate": true,
+	"stale-if-error":         true,
+	"no-cache":               false,
+	"no-store":               false,
+	"no-transform":           false,
+	"must-revalidate":        false,
+	"proxy-revalidate":       false,
+	"public":                 false,
+	"private":                false,
+	"immutable":              false,
+}
+
+//ParseCacheControl parses the directives of Cache-Control. The key of the returned map is the name of a directive in lower case
+func ParseCacheControl(directives string) (map[string]string, error) {
+	ret := make(map[string]string)
+	for _, d := range strings.Split(directives, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+
+		name, value := d, ""
+		if i := strings.Index(d, "="); i >= 0 {
+			name, value = strings.TrimSpace(d[:i]), strings.TrimSpace(d[i+1:])
+		}
+		name = strings.ToLower(name)
+
+		takesSeconds, found := cacheDirectives[name]
+		if !found {
+			return nil, fmt.Errorf("unknown directive %s", name)
+		}
+		if takesSeconds {
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				return nil, fmt.Errorf("the value of %s should be a non-negative number of seconds", name)
+			}
+		} else if value != "" {
+			return nil, fmt.Errorf("%s does not take a value", name)
+		}
+		ret[name] = value
+	}
+
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no directive")
+	}
+
+	return ret, nil
+}
+
+/*
+* Matches returns true if the rule matches urlPath. An extension matches the files ending with it,
+* a glob with slash matches the whole path and a glob without slash matches the base name
+ */
+func (r CacheRule) Matches(urlPath string) bool {
+	if strings.HasPrefix(r.Match, ".") && !strings.ContainsAny(r.Match, "*?[/") {
+		return strings.HasSuffix(urlPath, r.Match)
+	}
+
+	if !strings.Contains(r.Match, "/") {
+		matched, _ := path.Match(r.Match, path.Base(urlPath))
+		return matched
+	}
+
+	pattern := r.Match
+	if strings.HasSuffix(pattern, "/*") {
+		pattern += "*"
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(urlPath, "/"))
+}
+
+//matchSegments matches the segments of a path with the segments of a glob. The segment ** matches any number of segments
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+	matched, _ := path.Match(pattern[0], segments[0])
+
+	return matched && matchSegments(pattern[1:], segments[1:])
+}
+
+/*
+* Expires returns the value of Expires for the response sent at now. A time in the past is returned
+* for no-cache and no-store. false is returned if Expires should not be set
+ */
+func (r CacheRule) Expires(now time.Time) (time.Time, bool) {
+	directives := r.directives
+	if directives == nil {
+		//the rule has not been checked
+		var err error
+		if directives, err = ParseCacheControl(r.Directives); err != nil {
+			return time.Time{}, false
+		}
+	}
+
+	if _, found := directives["no-store"]; found {
+		return time.Unix(0, 0), true
+	}
+	if _, found := directives["no-cache"]; found {
+		return time.Unix(0, 0), true
+	}
+	if maxAge, found := directives["max-age"]; found {
+		seconds, _ := strconv.Atoi(maxAge)
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+
+	return time.Time{}, false
+}
+
+//checkCacheRules checks the Cache-Control rules in fieldPath and keeps the parsed directives in them
+func (settings *Configs) checkCacheRules(fieldPath string, rules []CacheRule) {
+	for i, r := range rules {
+		rulePath := fmt.Sprintf("%s[%d]", fieldPath, i)
+		if r.Match == "" {
+			settings.addError(rulePath+".match", "The pattern of the rule should not be empty")
+		} else if _, err := path.Match(r.Match, ""); err != nil {
+			settings.addError(rulePath+".match", "The pattern:%s is invalid: %s", r.Match, err)
+		}
+
+		directives, err := ParseCacheControl(r.Directives)
+		if err != nil {
+			settings.addError(rulePath+".directives", "The directives:%s are invalid: %s", r.Directives, err)
+		}
+		rules[i].directives = directives
+	}
+}
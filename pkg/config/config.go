@@ -19,14 +19,13 @@ package config
 
 import (
 	"fmt"
-	"io/ioutil"
 	"net"
-	"os"
+	"os/user"
 	"path"
 	"strings"
+	"time"
 
 	sysadmlog "github.com/wangyysde/bzhyserver/pkg/logger"
-	"gopkg.in/yaml.v2"
 )
 
 /* define default configs struct of application */
@@ -35,36 +34,74 @@ type appSetting struct {
 	Proversion string //The version of the release
 	Prefix     string //The path of the software will be installed
 	ConFile    string //The path of the configure file for the software
+	ConFormat  string //The format of the configure file. It is detected from the extension of ConFile if it is empty
 }
 
 //Struct for parsing server block of server file
 type server struct {
-	Listen   string `yaml: "listen"`
-	Port     int    `yaml: "port"`
-	RootPath string `yaml: "root"`
-	PidPath  string `yaml: "pid"`
-	Indexs   string `yaml: "index"`
+	Listen   string `yaml:"listen"`
+	Port     int    `yaml:"port"`
+	RootPath string `yaml:"root"`
+	PidPath  string `yaml:"pid"`
+	Indexs   string `yaml:"index"`
+	DirMode  string `yaml:"dirmode"` //the mode of the directory of pid file. It will be created by ApplyConfig if not exist
+	User     string `yaml:"user"`    //the user which the server runs as after binding the listen sockets. Empty for not changing
+	Group    string `yaml:"group"`   //the group which the server runs as. The primary group of User is used if it is empty
+
+	Listeners []Listener `yaml:"listeners"` //the server listens on all of them. A listener on Listen and Port is used if it is empty
+
+	HTTP2 http2Settings `yaml:"http2"` //the settings of HTTP/2 for all of the listeners serving h2 or h2c
+
+	Autoindex   Autoindex   `yaml:"autoindex"`   //list the directories without index file under root
+	Compression Compression `yaml:"compression"` //compress the responses for the clients which accept it
+	Cache       FileCache   `yaml:"cache"`       //cache the hot static files in memory
+
+	CacheControl []CacheRule `yaml:"cachecontrol"` //the rules of Cache-Control and Expires for the static files and proxied responses
+
+	//the pages of errors under root. Key is a status code such as 404, a range such as 500-599 or a class such as 5xx.
+	//the pages which paths end with .tmpl are executed as templates
+	ErrorPages map[string]string `yaml:"errorpages"`
+
+	Locations []Location `yaml:"locations"` //the locations for the requests. The files under root are served for the requests matching no location
+	Rewrite   Rewrite    `yaml:"rewrite"`   //the rules rewriting or redirecting the requests before routing
+	VHosts    []VHost    `yaml:"vhosts"`    //the name-based virtual hosts
+
+	ReadTimeout       time.Duration `yaml:"readtimeout"`       //the maximum duration for reading the entire request, including the body. 0 for no limit
+	ReadHeaderTimeout time.Duration `yaml:"readheadertimeout"` //the maximum duration for reading the request headers
+	WriteTimeout      time.Duration `yaml:"writetimeout"`      //the maximum duration before timing out writes of the response. 0 for no limit
+	IdleTimeout       time.Duration `yaml:"idletimeout"`       //the maximum duration to wait for the next request when keep-alives are enabled
+	MaxHeaderBytes    int           `yaml:"maxheaderbytes"`    //the maximum size of the request headers in bytes
 }
 
 //Struct for log block of config file
 type logger struct {
-	Loglevel  string `yaml: "loglevel"`
-	AccessLog string `yaml: "accesslog"`
-	ErrorLog  string `yaml: "errorlog"`
-	Logtype   string `yaml: "logtype"`
+	Loglevel  string `yaml:"loglevel"`
+	AccessLog string `yaml:"accesslog"`
+	ErrorLog  string `yaml:"errorlog"`
+	Logtype   string `yaml:"logtype"`
+	DirMode   string `yaml:"dirmode"` //the mode of the directories of log files. They will be created by ApplyConfig if not exist
 }
 
 //Struct for runtime settings
 type runtime struct {
 	Logger *sysadmlog.SysadmLogger //for Logger
+
+	files    []string            //config files have been loaded,including the fragments
+	lines    map[string]fieldPos //positions of the fields in config files. key is the path of a field,such as server.port
+	errors   []*FieldError       //problems found by CheckConfig
+	defaults []AppliedDefault    //defaults have been applied by CheckConfig
+	sources  map[string]string   //where the value of the fields come from. key is the path of a field
+	secrets  map[string]bool     //the fields which values are read from secret files
+
+	deprecations []string //warnings about the deprecated keys found by ParseConfig
 }
 
 //Struct for application configuration
 type Configs struct {
-	App     appSetting //for application
-	Server  server     //for server block
-	Logger  logger     //for log block
-	Runtime runtime
+	App     appSetting `yaml:"app"`    //for application
+	Server  server     `yaml:"server"` //for server block
+	Logger  logger     `yaml:"log"`    //for log block
+	Runtime runtime    `yaml:"-"`
 }
 
 //Initate a variable for application configuration
@@ -80,7 +117,11 @@ func New() *Configs {
 	return &Settings
 }
 
-//Read the values from confFile and put them into settings
+/*
+* Read the values from confFile and put them into settings.
+* the files included by confFile and the fragments in conf.d directory next to it are merged,
+* and then the references to environment variables and secret files in values are replaced
+ */
 func (settings *Configs) ParseConfig(confFile string) (err error) {
 	err = nil
 	if len(confFile) <= 0 {
@@ -89,72 +130,133 @@ func (settings *Configs) ParseConfig(confFile string) (err error) {
 		settings.App.ConFile = confFile
 	}
 
-	yamlFile, err := ioutil.ReadFile(confFile)
+	loader := newFragmentLoader()
+	root, err := loader.load(confFile, settings.App.ConFormat)
+	if err != nil {
+		return err
+	}
+
+	root, err = loader.loadConfDir(root, confFile)
 	if err != nil {
 		return err
 	}
 
-	err = yaml.Unmarshal(yamlFile, settings)
+	settings.Runtime.files = loader.order
+	settings.Runtime.lines = make(map[string]fieldPos)
+	settings.Runtime.sources = make(map[string]string)
+	settings.Runtime.deprecations = nil
+	if root == nil {
+		return nil
+	}
+
+	clearReplaceTags(root)
+	settings.Runtime.deprecations = renameDeprecatedKeys(root, loader.files)
+	settings.Runtime.secrets = make(map[string]bool)
+	if err = interpolate(root, "", loader.files, settings.Runtime.secrets); err != nil {
+		return err
+	}
+
+	collectLines(root, "", loader.files, settings.Runtime.lines)
+	for fieldPath := range settings.Runtime.lines {
+		settings.Runtime.sources[fieldPath] = SourceFile
+	}
+
+	err = root.Decode(settings)
 
 	return err
 }
 
+/*
+* CheckConfig checks all of the settings and applies defaults to the fields which are not set.
+* it does not return at the first problem, all problems are collected and returned as *CheckErrors.
+* CheckConfig does not write anything to disk, ApplyConfig should be called to prepare the directories
+ */
 func (settings *Configs) CheckConfig() (err error) {
-	err = nil
-	var fp *os.File
+	settings.Runtime.errors = nil
+	settings.Runtime.defaults = nil
 
 	//Checking whether the listen ip is valid
 	if len(settings.Server.Listen) > 0 {
 		if net.ParseIP(settings.Server.Listen) == nil {
-			err = fmt.Errorf("The Listen address:%s is invalid", settings.Server.Listen)
-			return err
+			settings.addError("server.listen", "The Listen address:%s is invalid", settings.Server.Listen)
 		}
 	} else {
 		settings.Server.Listen = defaultServerSettings.Listen
+		settings.applyDefault("server.listen", settings.Server.Listen)
 	}
 
 	//If there isn't port in the Yaml file
 	if settings.Server.Port == 0 {
 		settings.Server.Port = defaultServerSettings.Port
+		settings.applyDefault("server.port", settings.Server.Port)
 	}
 
-	if settings.Server.Port <= 1024 || settings.Server.Port > 65535 {
-		err = fmt.Errorf("The Port:%d  is invalid. The valid port number is between 1024 and 65535!", settings.Server.Port)
-		return err
+	if settings.Server.Port < 1 || settings.Server.Port > 65535 {
+		settings.addError("server.port", "The Port:%d  is invalid. The valid port number is between 1 and 65535!", settings.Server.Port)
 	}
 
+	settings.checkListeners()
+	settings.checkHTTP2()
+	settings.checkTimeouts()
+	settings.checkCompression("server.compression", &settings.Server.Compression)
+	settings.checkFileCache()
+	settings.checkCacheRules("server.cachecontrol", settings.Server.CacheControl)
+
 	if len(settings.Server.RootPath) == 0 {
 		settings.Server.RootPath = defaultServerSettings.RootPath
+		settings.applyDefault("server.root", settings.Server.RootPath)
 	}
 	if !path.IsAbs(settings.Server.RootPath) {
 		settings.Server.RootPath = path.Join(DefaultAppSettings.Prefix, settings.Server.RootPath)
 	}
 
 	if !path.IsAbs(settings.Server.RootPath) {
-		err = fmt.Errorf("The path of static file:%s is invalid ", settings.Server.RootPath)
-		return err
+		settings.addError("server.root", "The path of static file:%s is invalid ", settings.Server.RootPath)
 	}
+	settings.checkErrorPages()
+	settings.checkLocations("server.locations", settings.Server.Locations)
+	settings.checkRewrite("server.rewrite", &settings.Server.Rewrite)
 
 	if len(settings.Server.PidPath) == 0 {
 		settings.Server.PidPath = defaultServerSettings.PidPath
+		settings.applyDefault("server.pid", settings.Server.PidPath)
 	}
 
 	if !path.IsAbs(settings.Server.PidPath) {
 		settings.Server.PidPath = path.Join(DefaultAppSettings.Prefix, settings.Server.PidPath)
 	}
 
-	fp, err = os.OpenFile(settings.Server.PidPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
-	if err != nil {
-		return err
+	settings.checkWritableFile("server.pid", settings.Server.PidPath)
+
+	if len(settings.Server.DirMode) == 0 {
+		settings.Server.DirMode = defaultServerSettings.DirMode
+		settings.applyDefault("server.dirmode", settings.Server.DirMode)
+	}
+
+	if _, err = parseDirMode(settings.Server.DirMode); err != nil {
+		settings.addError("server.dirmode", "%s", err)
+	}
+
+	if len(settings.Server.User) > 0 {
+		if _, err = user.Lookup(settings.Server.User); err != nil {
+			settings.addError("server.user", "%s", err)
+		}
+	}
+
+	if len(settings.Server.Group) > 0 {
+		if _, err = user.LookupGroup(settings.Server.Group); err != nil {
+			settings.addError("server.group", "%s", err)
+		}
 	}
-	fp.Close()
 
 	if len(settings.Server.Indexs) == 0 {
 		settings.Server.Indexs = defaultServerSettings.Indexs
+		settings.applyDefault("server.index", settings.Server.Indexs)
 	}
 
 	if len(settings.Logger.Loglevel) == 0 {
 		settings.Logger.Loglevel = defaultLoggerSettings.Loglevel
+		settings.applyDefault("log.loglevel", settings.Logger.Loglevel)
 	}
 
 	found := -1
@@ -166,47 +268,99 @@ func (settings *Configs) CheckConfig() (err error) {
 	}
 
 	if found < 0 {
-		err = fmt.Errorf("The loglevel:%s is invalid", settings.Logger.Loglevel)
-		return err
+		settings.addError("log.loglevel", "The loglevel:%s is invalid", settings.Logger.Loglevel)
+	}
+
+	if len(settings.Logger.DirMode) == 0 {
+		settings.Logger.DirMode = defaultLoggerSettings.DirMode
+		settings.applyDefault("log.dirmode", settings.Logger.DirMode)
+	}
+
+	if _, err = parseDirMode(settings.Logger.DirMode); err != nil {
+		settings.addError("log.dirmode", "%s", err)
 	}
 
 	if len(settings.Logger.AccessLog) == 0 {
 		settings.Logger.AccessLog = defaultLoggerSettings.AccessLog
+		settings.applyDefault("log.accesslog", settings.Logger.AccessLog)
 	}
 
 	if !path.IsAbs(settings.Logger.AccessLog) {
 		settings.Logger.AccessLog = path.Join(DefaultAppSettings.Prefix, settings.Logger.AccessLog)
 	}
 
-	fp, err = os.OpenFile(settings.Logger.AccessLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
-	if err != nil {
-		return err
-	}
-	fp.Close()
+	settings.checkWritableFile("log.accesslog", settings.Logger.AccessLog)
 
 	if len(settings.Logger.ErrorLog) == 0 {
 		settings.Logger.ErrorLog = defaultLoggerSettings.ErrorLog
+		settings.applyDefault("log.errorlog", settings.Logger.ErrorLog)
 	}
 
 	if !path.IsAbs(settings.Logger.ErrorLog) {
 		settings.Logger.ErrorLog = path.Join(DefaultAppSettings.Prefix, settings.Logger.ErrorLog)
 	}
 
-	fp, err = os.OpenFile(settings.Logger.ErrorLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
-	if err != nil {
-		return err
-	}
-	fp.Close()
+	settings.checkWritableFile("log.errorlog", settings.Logger.ErrorLog)
+	settings.checkVHosts()
 
 	if len(settings.Logger.Logtype) < 1 {
 		settings.Logger.Logtype = defaultLoggerSettings.Logtype
+		settings.applyDefault("log.logtype", settings.Logger.Logtype)
 	}
 
 	if strings.ToLower(settings.Logger.Logtype) != "text" && strings.ToLower(settings.Logger.Logtype) != "json" {
-		err = fmt.Errorf("The logType:%s is invalid", settings.Logger.Logtype)
-		return err
+		settings.addError("log.logtype", "The logType:%s is invalid", settings.Logger.Logtype)
 	}
 
-	return err
+	if len(settings.Runtime.errors) > 0 {
+		return &CheckErrors{
+			File:     settings.App.ConFile,
+			Errors:   settings.Runtime.errors,
+			Defaults: settings.Runtime.defaults,
+		}
+	}
+
+	return nil
+
+}
+
+/*
+* ApplyConfig prepares the environment for the settings which have been checked by CheckConfig.
+* It creates the parent directories of pid file and log files with the modes specified by dirmode.
+* The files themselves will be created when they are opened by the server.
+ */
+func (settings *Configs) ApplyConfig() (err error) {
+	settings.Runtime.errors = nil
+
+	serverMode, err := parseDirMode(settings.Server.DirMode)
+	if err != nil {
+		settings.addError("server.dirmode", "%s", err)
+	} else {
+		settings.createParentDir("server.pid", settings.Server.PidPath, serverMode)
+	}
+
+	logMode, err := parseDirMode(settings.Logger.DirMode)
+	if err != nil {
+		settings.addError("log.dirmode", "%s", err)
+	} else {
+		settings.createParentDir("log.accesslog", settings.Logger.AccessLog, logMode)
+		settings.createParentDir("log.errorlog", settings.Logger.ErrorLog, logMode)
+		for i, v := range settings.Server.VHosts {
+			if v.AccessLog != "" {
+				settings.createParentDir(fmt.Sprintf("server.vhosts[%d].accesslog", i), v.AccessLog, logMode)
+			}
+			if v.ErrorLog != "" {
+				settings.createParentDir(fmt.Sprintf("server.vhosts[%d].errorlog", i), v.ErrorLog, logMode)
+			}
+		}
+	}
+
+	if len(settings.Runtime.errors) > 0 {
+		return &CheckErrors{
+			File:   settings.App.ConFile,
+			Errors: settings.Runtime.errors,
+		}
+	}
 
+	return nil
 }
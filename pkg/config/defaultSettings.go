@@ -34,6 +34,7 @@ var defaultServerSettings = server{
     RootPath: "html",
     PidPath:  "/var/run/sysadm.pid",
     Indexs:   "index.html index.htm",
+    DirMode:  "0755",
 }
 
 var defaultLoggerSettings = logger{
@@ -41,5 +42,6 @@ var defaultLoggerSettings = logger{
     AccessLog: "logs/sysadm-access.log",
     ErrorLog:  "logs/sysadm-error.log",
     Logtype:   "text",
+    DirMode:   "0755",
 }
 
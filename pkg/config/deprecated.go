@@ -0,0 +1,106 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 14 2021
+**/
+
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+//Struct for a key which was used by the former releases and has been renamed
+type deprecatedKey struct {
+	block string //the block which the key is in. empty for the top level
+	old   string
+	new   string
+}
+
+/*
+* deprecatedKeys are the keys which were accepted by the former releases.
+* they are renamed to the current keys while parsing, and a warning is reported for each of them
+ */
+var deprecatedKeys = []deprecatedKey{
+	{block: "", old: "logger", new: "log"},
+	{block: "server", old: "rootpath", new: "root"},
+	{block: "server", old: "pidpath", new: "pid"},
+	{block: "server", old: "indexs", new: "index"},
+}
+
+/*
+* renameDeprecatedKeys renames the deprecated keys in root to the current keys and returns the warnings.
+* a deprecated key is ignored if the current key is set too. files maps a node to the file it come from
+ */
+func renameDeprecatedKeys(root *yaml.Node, files map[*yaml.Node]string) (warnings []string) {
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+
+	for _, d := range deprecatedKeys {
+		block := root
+		if d.block != "" {
+			if block = mappingValue(root, d.block); block == nil {
+				continue
+			}
+		}
+		if block.Kind != yaml.MappingNode {
+			continue
+		}
+
+		oldPath, newPath := d.old, d.new
+		if d.block != "" {
+			oldPath, newPath = d.block+"."+d.old, d.block+"."+d.new
+		}
+		for i := 0; i+1 < len(block.Content); i += 2 {
+			key := block.Content[i]
+			if key.Value != d.old {
+				continue
+			}
+
+			pos := fmt.Sprintf("%s line %d", files[key], key.Line)
+			if mappingValue(block, d.new) != nil {
+				warnings = append(warnings, fmt.Sprintf("%s (%s) is deprecated and ignored, because %s is set", oldPath, pos, newPath))
+				block.Content = append(block.Content[:i], block.Content[i+2:]...)
+			} else {
+				warnings = append(warnings, fmt.Sprintf("%s (%s) is deprecated, use %s instead", oldPath, pos, newPath))
+				key.Value = d.new
+			}
+			break
+		}
+	}
+
+	return warnings
+}
+
+//mappingValue returns the value of key in the mapping node. nil is returned if there is not
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+//Deprecations returns the warnings about the deprecated keys found by last calling of ParseConfig
+func (settings *Configs) Deprecations() []string {
+	return settings.Runtime.deprecations
+}
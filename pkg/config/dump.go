@@ -0,0 +1,160 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 21 2021
+**/
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+/*
+* Effective returns the effective settings as nested maps which keys are the same as config file.
+* it should be called after CheckConfig, so the defaults have been applied. secrets are masked
+ */
+func (settings *Configs) Effective() map[string]interface{} {
+	return settings.effectiveValue(reflect.ValueOf(settings).Elem(), "", false).(map[string]interface{})
+}
+
+//effectiveValue converts v to the value which can be marshaled to YAML or JSON
+func (settings *Configs) effectiveValue(v reflect.Value, fieldPath string, secret bool) interface{} {
+	if secret || settings.isSecret(fieldPath) || (v.Kind() == reflect.String && containsPrivateKey(v.String())) {
+		if v.IsZero() {
+			return fieldString(v)
+		}
+		return secretMask
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		values := make(map[string]interface{})
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := yamlName(f)
+			if name == "" || (fieldPath == "" && name == "app") {
+				continue
+			}
+			childPath := name
+			if fieldPath != "" {
+				childPath = fieldPath + "." + name
+			}
+			values[name] = settings.effectiveValue(v.Field(i), childPath, f.Tag.Get("secret") == "true")
+		}
+		return values
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+		items := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items[i] = settings.effectiveValue(v.Index(i), fmt.Sprintf("%s[%d]", fieldPath, i), false)
+		}
+		return items
+	case reflect.Map:
+		values := make(map[string]interface{})
+		iter := v.MapRange()
+		for iter.Next() {
+			key := fmt.Sprintf("%v", iter.Key().Interface())
+			values[key] = settings.effectiveValue(iter.Value(), fieldPath+"."+key, false)
+		}
+		return values
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return settings.effectiveValue(v.Elem(), fieldPath, false)
+	}
+
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+
+	return v.Interface()
+}
+
+/*
+* Schema returns a JSON Schema of the config file, which can be used by editors for
+* completing and validating config files
+ */
+func Schema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Configs{}), true)
+	schema["properties"].(map[string]interface{})[includeKey] = map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+	}
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = DefaultAppSettings.Progname + " configuration"
+
+	return schema
+}
+
+//typeSchema returns the schema of t. top is true for Configs which app block is skipped
+func typeSchema(t reflect.Type, top bool) map[string]interface{} {
+	if t == durationType {
+		return map[string]interface{}{"type": "string", "pattern": "^([0-9]+(\\.[0-9]+)?(ns|us|ms|s|m|h))+$"}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := yamlName(f)
+			if name == "" || (top && name == "app") {
+				continue
+			}
+			properties[name] = typeSchema(f.Type, false)
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), false)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), false)}
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), false)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+
+	return map[string]interface{}{"type": "string"}
+}
+
+//yamlName returns the key of the field in config file. empty if the field is not in config file
+func yamlName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if name == "-" || f.PkgPath != "" {
+		return ""
+	}
+
+	return name
+}
@@ -0,0 +1,87 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified May 03 2021
+**/
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//The error pages which paths end with it are executed as html/template
+const ErrorPageTemplateExt = ".tmpl"
+
+/*
+* ParseStatusRange parses the key of error pages into the range of status codes. The key may be
+* a code such as 404, a range such as 500-599 or a class such as 5xx
+ */
+func ParseStatusRange(key string) (int, int, error) {
+	var low, high int
+	var err error
+	key = strings.ToLower(strings.TrimSpace(key))
+
+	switch {
+	case len(key) == 3 && strings.HasSuffix(key, "xx"):
+		if low, err = strconv.Atoi(key[:1]); err != nil {
+			return 0, 0, fmt.Errorf("invalid class %s", key)
+		}
+		low, high = low*100, low*100+99
+	case strings.Contains(key, "-"):
+		i := strings.Index(key, "-")
+		if low, err = strconv.Atoi(strings.TrimSpace(key[:i])); err == nil {
+			high, err = strconv.Atoi(strings.TrimSpace(key[i+1:]))
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid range %s", key)
+		}
+	default:
+		if low, err = strconv.Atoi(key); err != nil {
+			return 0, 0, fmt.Errorf("invalid status code %s", key)
+		}
+		high = low
+	}
+
+	if low < 400 || high > 599 || low > high {
+		return 0, 0, fmt.Errorf("the status codes %s should be between 400 and 599", key)
+	}
+
+	return low, high, nil
+}
+
+//checkErrorPages checks the keys of error pages and the paths of the pages under root
+func (settings *Configs) checkErrorPages() {
+	//the keys are sorted, so the errors are reported in the same order every time
+	keys := make([]string, 0, len(settings.Server.ErrorPages))
+	for key := range settings.Server.ErrorPages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		page := settings.Server.ErrorPages[key]
+		fieldPath := "server.errorpages." + key
+		if _, _, err := ParseStatusRange(key); err != nil {
+			settings.addError(fieldPath, "The key of error page is invalid: %s", err)
+		}
+
+		if page == "" || strings.HasSuffix(page, "/") || strings.Contains("/"+page+"/", "/../") {
+			settings.addError(fieldPath, "The error page:%s should be the path of a file under root", page)
+		}
+	}
+}
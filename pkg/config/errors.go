@@ -0,0 +1,143 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 14 2021
+**/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//Struct for a problem found in a field of the configuration
+type FieldError struct {
+	Path string //YAML path of the field, such as server.port
+	File string //The config file or fragment which the field is in. empty if the field is not in any file
+	Line int    //Line number of the field in the config file. 0 if the field is not in the file
+	Msg  string
+}
+
+func (e *FieldError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s (%s line %d): %s", e.Path, e.File, e.Line, e.Msg)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+//Struct for the position of a field in config files
+type fieldPos struct {
+	File string
+	Line int
+}
+
+//Struct for a default value which has been applied to a field that not set in the config file
+type AppliedDefault struct {
+	Path  string //YAML path of the field
+	Value string //The default value has been applied
+}
+
+/*
+* CheckErrors is returned by CheckConfig when one or more fields are invalid.
+* It holds all of the problems found, so they can be reported at once
+ */
+type CheckErrors struct {
+	File     string           //The path of the config file has been checked
+	Errors   []*FieldError    //All problems found
+	Defaults []AppliedDefault //Defaults have been applied while checking
+}
+
+func (e *CheckErrors) Error() string {
+	return strings.Join(e.Lines(), "\n")
+}
+
+/*
+* Lines returns the messages of CheckErrors one per line,
+* so callers can log them one by one
+ */
+func (e *CheckErrors) Lines() (lines []string) {
+	lines = append(lines, fmt.Sprintf("Config file %s has %d error(s):", e.File, len(e.Errors)))
+	for _, fe := range e.Errors {
+		lines = append(lines, "  "+fe.Error())
+	}
+
+	if len(e.Defaults) > 0 {
+		lines = append(lines, "Defaults applied:")
+		for _, d := range e.Defaults {
+			lines = append(lines, fmt.Sprintf("  %s = %s", d.Path, d.Value))
+		}
+	}
+
+	return lines
+}
+
+/*
+* addError add a problem of the field which path is fieldPath to settings.
+* the line number of the field is looked up from the parsed config file
+ */
+func (settings *Configs) addError(fieldPath string, format string, args ...interface{}) {
+	pos := settings.Runtime.lines[fieldPath]
+	settings.Runtime.errors = append(settings.Runtime.errors, &FieldError{
+		Path: fieldPath,
+		File: pos.File,
+		Line: pos.Line,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+//applyDefault records the default value which has been applied to the field which path is fieldPath
+func (settings *Configs) applyDefault(fieldPath string, value interface{}) {
+	settings.Runtime.defaults = append(settings.Runtime.defaults, AppliedDefault{
+		Path:  fieldPath,
+		Value: fmt.Sprintf("%v", value),
+	})
+	settings.setSource(fieldPath, SourceDefault)
+}
+
+//AppliedDefaults returns the defaults which have been applied by last calling of CheckConfig
+func (settings *Configs) AppliedDefaults() []AppliedDefault {
+	return settings.Runtime.defaults
+}
+
+/*
+* collectLines walks the yaml node tree and saves the file and line number of every field into lines.
+* the key of lines is the path of the field, such as server.port. files maps a node to the file it come from
+ */
+func collectLines(node *yaml.Node, prefix string, files map[*yaml.Node]string, lines map[string]fieldPos) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			collectLines(n, prefix, files, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			lines[key] = fieldPos{File: files[node.Content[i]], Line: node.Content[i].Line}
+			collectLines(node.Content[i+1], key, files, lines)
+		}
+	case yaml.SequenceNode:
+		for i, n := range node.Content {
+			key := fmt.Sprintf("%s[%d]", prefix, i)
+			lines[key] = fieldPos{File: files[n], Line: n.Line}
+			collectLines(n, key, files, lines)
+		}
+	}
+}
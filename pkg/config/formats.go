@@ -0,0 +1,180 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 20 2021
+**/
+
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hashicorp/hcl"
+	"gopkg.in/yaml.v3"
+)
+
+//Formats of config file
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+	FormatHCL  = "hcl"
+)
+
+//Formats returns the supported formats of config file
+func Formats() []string {
+	return []string{FormatYAML, FormatJSON, FormatTOML, FormatHCL}
+}
+
+//extensions of config files for every format
+var formatExtensions = map[string]string{
+	".yaml": FormatYAML,
+	".yml":  FormatYAML,
+	".json": FormatJSON,
+	".toml": FormatTOML,
+	".hcl":  FormatHCL,
+}
+
+/*
+* detectFormat returns the format of file. format is used if it is not empty,
+* otherwise the format is detected from the extension of file. yaml is the default
+ */
+func detectFormat(file string, format string) (string, error) {
+	if format != "" {
+		format = strings.ToLower(format)
+		if format == "yml" {
+			format = FormatYAML
+		}
+		for _, f := range Formats() {
+			if f == format {
+				return format, nil
+			}
+		}
+		return "", fmt.Errorf("The config format:%s is invalid. It should be one of %s", format, strings.Join(Formats(), ","))
+	}
+
+	if f, found := formatExtensions[strings.ToLower(filepath.Ext(file))]; found {
+		return f, nil
+	}
+
+	return FormatYAML, nil
+}
+
+/*
+* decodeDocument decodes content in format to a yaml document node, so all formats can be merged and
+* checked in the same way. JSON is parsed by the yaml parser for keeping line numbers,
+* the line numbers of TOML and HCL are not available
+ */
+func decodeDocument(content []byte, format string) (*yaml.Node, error) {
+	var doc yaml.Node
+	var values map[string]interface{}
+
+	switch format {
+	case FormatYAML, FormatJSON:
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return nil, err
+		}
+		return &doc, nil
+	case FormatTOML:
+		if err := toml.Unmarshal(content, &values); err != nil {
+			return nil, err
+		}
+	case FormatHCL:
+		if err := hcl.Unmarshal(content, &values); err != nil {
+			return nil, err
+		}
+		values = flattenHCL(values, reflect.TypeOf(Configs{})).(map[string]interface{})
+	default:
+		return nil, fmt.Errorf("unsupported config format %s", format)
+	}
+
+	var root yaml.Node
+	if err := root.Encode(values); err != nil {
+		return nil, err
+	}
+	doc.Kind = yaml.DocumentNode
+	doc.Content = []*yaml.Node{&root}
+
+	return &doc, nil
+}
+
+/*
+* flattenHCL converts the blocks decoded by hcl, which are lists of maps, to maps when the fields are
+* structs or maps in t. such as server { port = 8080 } is decoded to server: [{port: 8080}] by hcl.
+* the blocks of the fields which are slices are kept as lists, even if there is only one block
+ */
+func flattenHCL(v interface{}, t reflect.Type) interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch value := v.(type) {
+	case []map[string]interface{}:
+		if t != nil && t.Kind() == reflect.Slice {
+			items := make([]interface{}, len(value))
+			for i, item := range value {
+				items[i] = flattenHCL(item, t.Elem())
+			}
+			return items
+		}
+		if len(value) == 1 {
+			return flattenHCL(value[0], t)
+		}
+		items := make([]interface{}, len(value))
+		for i, item := range value {
+			items[i] = flattenHCL(item, nil)
+		}
+		return items
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = flattenHCL(item, hclFieldType(t, k))
+		}
+		return value
+	case []interface{}:
+		var elem reflect.Type
+		if t != nil && t.Kind() == reflect.Slice {
+			elem = t.Elem()
+		}
+		for i, item := range value {
+			value[i] = flattenHCL(item, elem)
+		}
+		return value
+	}
+
+	return v
+}
+
+//hclFieldType returns the type of the value of key in t, which is a struct or a map. nil is returned if it is unknown
+func hclFieldType(t reflect.Type, key string) reflect.Type {
+	if t == nil {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem()
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if yamlName(t.Field(i)) == key {
+				return t.Field(i).Type
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 27 2021
+**/
+
+package config
+
+import (
+	"time"
+)
+
+//The range of the size of HTTP/2 frames defined by RFC 7540
+const (
+	minHTTP2FrameSize = 1 << 14
+	maxHTTP2FrameSize = 1<<24 - 1
+)
+
+//Struct for HTTP/2 settings in server block
+type http2Settings struct {
+	MaxConcurrentStreams uint32        `yaml:"maxconcurrentstreams"` //the maximum number of concurrent streams per connection
+	MaxReadFrameSize     uint32        `yaml:"maxreadframesize"`     //the largest frame the server is willing to read, between 16384 and 16777215
+	IdleTimeout          time.Duration `yaml:"idletimeout"`          //idle connections are closed after it. 0 for using the idle timeout of the server
+}
+
+//Define default value for HTTP/2 settings
+var defaultHTTP2Settings = http2Settings{
+	MaxConcurrentStreams: 250,
+	MaxReadFrameSize:     1 << 20,
+}
+
+/*
+* Define default value for timeouts and limits of the server.
+* readtimeout and writetimeout limit the whole request and response, so they are not set by default
+* for not cutting off long uploads and downloads. The slow clients are limited by readheadertimeout and idletimeout
+ */
+var defaultTimeoutSettings = server{
+	ReadHeaderTimeout: 10 * time.Second,
+	IdleTimeout:       120 * time.Second,
+	MaxHeaderBytes:    1 << 20,
+}
+
+//The range of server.maxheaderbytes
+const (
+	minHeaderBytes = 1 << 12
+	maxHeaderBytes = 1 << 26
+)
+
+/*
+* checkTimeouts checks the timeouts and the size limit of request headers in server block and
+* sets the default values for the settings not set, so there is always a limit for slow clients.
+* readtimeout and writetimeout are 0 if they are not set, which means no limit
+ */
+func (settings *Configs) checkTimeouts() {
+	s := &settings.Server
+	timeouts := []struct {
+		path  string
+		value *time.Duration
+		def   time.Duration
+	}{
+		{"server.readtimeout", &s.ReadTimeout, defaultTimeoutSettings.ReadTimeout},
+		{"server.readheadertimeout", &s.ReadHeaderTimeout, defaultTimeoutSettings.ReadHeaderTimeout},
+		{"server.writetimeout", &s.WriteTimeout, defaultTimeoutSettings.WriteTimeout},
+		{"server.idletimeout", &s.IdleTimeout, defaultTimeoutSettings.IdleTimeout},
+	}
+	for _, t := range timeouts {
+		if *t.value == 0 && t.def != 0 {
+			*t.value = t.def
+			settings.applyDefault(t.path, *t.value)
+		}
+		if *t.value < 0 {
+			settings.addError(t.path, "The timeout:%s should be positive", *t.value)
+		}
+	}
+
+	if s.ReadTimeout > 0 && s.ReadHeaderTimeout > s.ReadTimeout {
+		settings.addError("server.readheadertimeout", "The timeout:%s should not be longer than readtimeout:%s", s.ReadHeaderTimeout, s.ReadTimeout)
+	}
+
+	if s.MaxHeaderBytes == 0 {
+		s.MaxHeaderBytes = defaultTimeoutSettings.MaxHeaderBytes
+		settings.applyDefault("server.maxheaderbytes", s.MaxHeaderBytes)
+	}
+	if s.MaxHeaderBytes < minHeaderBytes || s.MaxHeaderBytes > maxHeaderBytes {
+		settings.addError("server.maxheaderbytes", "The size:%d is invalid. It should be between %d and %d", s.MaxHeaderBytes, minHeaderBytes, maxHeaderBytes)
+	}
+}
+
+//checkHTTP2 checks the HTTP/2 settings and sets the default values for the settings not set
+func (settings *Configs) checkHTTP2() {
+	h2 := &settings.Server.HTTP2
+	if h2.MaxConcurrentStreams == 0 {
+		h2.MaxConcurrentStreams = defaultHTTP2Settings.MaxConcurrentStreams
+		settings.applyDefault("server.http2.maxconcurrentstreams", h2.MaxConcurrentStreams)
+	}
+
+	if h2.MaxReadFrameSize == 0 {
+		h2.MaxReadFrameSize = defaultHTTP2Settings.MaxReadFrameSize
+		settings.applyDefault("server.http2.maxreadframesize", h2.MaxReadFrameSize)
+	}
+	if h2.MaxReadFrameSize < minHTTP2FrameSize || h2.MaxReadFrameSize > maxHTTP2FrameSize {
+		settings.addError("server.http2.maxreadframesize", "The frame size:%d is invalid. It should be between %d and %d", h2.MaxReadFrameSize, minHTTP2FrameSize, maxHTTP2FrameSize)
+	}
+
+	if h2.IdleTimeout < 0 {
+		settings.addError("server.http2.idletimeout", "The idle timeout:%s should not be negative", h2.IdleTimeout)
+	}
+}
@@ -0,0 +1,254 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 17 2021
+**/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+* Merging rules for config fragments:
+* fragments are merged in the order they are loaded: the main config file, the files included by it
+* (in the order of include list and lexical order of the files matched by a glob) and then the
+* fragments in conf.d directory which is next to the main config file.
+* For the same key, a mapping is merged recursively, a sequence is appended to the former one
+* and a scalar overrides the former one. A sequence tagged with !replace overrides the former one.
+ */
+const (
+	includeKey = "include"  //key of include directives in a config file
+	confDir    = "conf.d"   //the directory of fragments next to the main config file
+	replaceTag = "!replace" //tag for a sequence which overrides the former one
+)
+
+//Struct for loading a config file and the fragments included by it
+type fragmentLoader struct {
+	stack  []string              //files being loaded, for detecting include cycles
+	loaded map[string]bool       //files have been loaded
+	files  map[*yaml.Node]string //the file which a node come from
+	order  []string              //files in the order they have been loaded
+}
+
+func newFragmentLoader() *fragmentLoader {
+	return &fragmentLoader{
+		loaded: make(map[string]bool),
+		files:  make(map[*yaml.Node]string),
+	}
+}
+
+/*
+* load reads file and the files included by it, and returns the merged mapping node.
+* the format of file is detected from its extension if format is empty.
+* a nil node will be returned if file is empty or has been loaded
+ */
+func (l *fragmentLoader) load(file string, format string) (*yaml.Node, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range l.stack {
+		if f == abs {
+			return nil, fmt.Errorf("include cycle: %s", strings.Join(append(l.stack[i:], abs), " -> "))
+		}
+	}
+
+	if l.loaded[abs] {
+		return nil, nil
+	}
+	l.loaded[abs] = true
+	l.order = append(l.order, abs)
+
+	content, err := ioutil.ReadFile(abs)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err = detectFormat(abs, format)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := decodeDocument(content, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", abs, err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s (line %d): the top level of a config file should be a mapping", abs, root.Line)
+	}
+	l.markFile(root, abs)
+
+	includes, err := takeIncludes(root)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", abs, err)
+	}
+
+	l.stack = append(l.stack, abs)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	for _, pattern := range includes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(abs), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include %s: %s", abs, pattern, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("%s: include %s: no such file", abs, pattern)
+		}
+
+		for _, m := range matches {
+			fragment, err := l.load(m, "")
+			if err != nil {
+				return nil, err
+			}
+			root = mergeNodes(root, fragment)
+		}
+	}
+
+	return root, nil
+}
+
+/*
+* loadConfDir loads the fragments in conf.d directory next to confFile and merges them to root.
+* only the files with the extension of a supported format are loaded
+ */
+func (l *fragmentLoader) loadConfDir(root *yaml.Node, confFile string) (*yaml.Node, error) {
+	dir := filepath.Join(filepath.Dir(confFile), confDir)
+	var matches []string
+	for ext := range formatExtensions {
+		m, err := filepath.Glob(filepath.Join(dir, "*"+ext))
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m...)
+	}
+
+	sort.Strings(matches)
+	for _, m := range matches {
+		fragment, err := l.load(m, "")
+		if err != nil {
+			return nil, err
+		}
+		root = mergeNodes(root, fragment)
+	}
+
+	return root, nil
+}
+
+//markFile records node and its children come from file
+func (l *fragmentLoader) markFile(node *yaml.Node, file string) {
+	l.files[node] = file
+	for _, n := range node.Content {
+		l.markFile(n, file)
+	}
+}
+
+/*
+* takeIncludes removes the include key from the mapping node root and returns the patterns of it.
+* the value of include can be a string or a sequence of strings
+ */
+func takeIncludes(root *yaml.Node) ([]string, error) {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != includeKey {
+			continue
+		}
+
+		value := root.Content[i+1]
+		root.Content = append(root.Content[:i:i], root.Content[i+2:]...)
+
+		var patterns []string
+		switch value.Kind {
+		case yaml.ScalarNode:
+			patterns = append(patterns, value.Value)
+		case yaml.SequenceNode:
+			for _, n := range value.Content {
+				if n.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("(line %d): include should be a list of file patterns", n.Line)
+				}
+				patterns = append(patterns, n.Value)
+			}
+		default:
+			return nil, fmt.Errorf("(line %d): include should be a file pattern or a list of them", value.Line)
+		}
+
+		return patterns, nil
+	}
+
+	return nil, nil
+}
+
+//mergeNodes merges src into dst according to the merging rules and returns the result
+func mergeNodes(dst *yaml.Node, src *yaml.Node) *yaml.Node {
+	if src == nil {
+		return dst
+	}
+	if dst == nil {
+		return src
+	}
+
+	switch {
+	case dst.Kind == yaml.MappingNode && src.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			key, value := src.Content[i], src.Content[i+1]
+			found := false
+			for j := 0; j+1 < len(dst.Content); j += 2 {
+				if dst.Content[j].Value == key.Value {
+					dst.Content[j] = key
+					dst.Content[j+1] = mergeNodes(dst.Content[j+1], value)
+					found = true
+					break
+				}
+			}
+			if !found {
+				dst.Content = append(dst.Content, key, value)
+			}
+		}
+		return dst
+	case dst.Kind == yaml.SequenceNode && src.Kind == yaml.SequenceNode && src.Tag != replaceTag:
+		dst.Content = append(dst.Content, src.Content...)
+		return dst
+	}
+
+	if src.Tag == replaceTag {
+		src.Tag = ""
+	}
+
+	return src
+}
+
+//clearReplaceTags removes the !replace tags which have not been consumed by mergeNodes
+func clearReplaceTags(node *yaml.Node) {
+	if node.Tag == replaceTag {
+		node.Tag = ""
+	}
+	for _, n := range node.Content {
+		clearReplaceTags(n)
+	}
+}
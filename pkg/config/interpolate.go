@@ -0,0 +1,150 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 19 2021
+**/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+* References can be used in the values of config:
+* ${NAME} is replaced by the value of environment variable NAME, it is an error if NAME is not set.
+* ${NAME:-default} is replaced by default if NAME is not set or empty.
+* $${ is replaced by ${ without interpolating.
+* A value in the form of file:///path is replaced by the content of the file, which is used for secrets.
+* The cert and key fields of TLS accept the content in PEM as well as a path, so keys can be read this way.
+* The fields which values come from files are treated as secrets and they are masked when dumping.
+* The private keys are masked too, as well as any value containing a private key in PEM, such as one from ${NAME}.
+ */
+const (
+	secretScheme = "file://"
+	secretMask   = "******"
+)
+
+/*
+* interpolate replaces the references in the scalar nodes of node. the fields which values are read
+* from files are saved to secrets. files maps a node to the file it come from, for error messages
+ */
+func interpolate(node *yaml.Node, prefix string, files map[*yaml.Node]string, secrets map[string]bool) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if err := interpolate(node.Content[i+1], key, files, secrets); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, n := range node.Content {
+			if err := interpolate(n, fmt.Sprintf("%s[%d]", prefix, i), files, secrets); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		value, secret, err := expandValue(node.Value)
+		if err != nil {
+			return fmt.Errorf("%s (%s line %d): %s", prefix, files[node], node.Line, err)
+		}
+		if value != node.Value {
+			node.Value = value
+			//the type of the value should be resolved again, such as a port number from ${PORT}
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+		if secret {
+			secrets[prefix] = true
+		}
+	}
+
+	return nil
+}
+
+/*
+* expandValue returns s with the references replaced.
+* secret is true if the value is read from a file
+ */
+func expandValue(s string) (value string, secret bool, err error) {
+	if strings.HasPrefix(s, secretScheme) {
+		file := strings.TrimPrefix(s, secretScheme)
+		if !filepath.IsAbs(file) {
+			return "", false, fmt.Errorf("the path of %s should be absolute", s)
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", false, err
+		}
+		return strings.TrimRight(string(content), "\r\n"), true, nil
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+
+		end := strings.Index(s[i:], "}")
+		if end < 0 {
+			return "", false, fmt.Errorf("unterminated reference in %q", s)
+		}
+		b.WriteString(s[:i])
+
+		name, def := s[i+2:i+end], ""
+		hasDefault := false
+		if j := strings.Index(name, ":-"); j >= 0 {
+			name, def, hasDefault = name[:j], name[j+2:], true
+		}
+		if name == "" {
+			return "", false, fmt.Errorf("empty variable name in %q", s)
+		}
+
+		v, found := os.LookupEnv(name)
+		switch {
+		case hasDefault && v == "":
+			v = def
+		case !found:
+			return "", false, fmt.Errorf("environment variable %s is not set", name)
+		}
+		b.WriteString(v)
+		s = s[i+end+1:]
+	}
+
+	return b.String(), false, nil
+}
+
+//isSecret returns true if the value of the field should be masked when it is dumped
+func (settings *Configs) isSecret(fieldPath string) bool {
+	return settings.Runtime.secrets[fieldPath]
+}
@@ -0,0 +1,512 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 24 2021
+**/
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//The prefix of the address of a unix domain socket listener
+const unixPrefix = "unix:"
+
+//Protocols can be served by a listener
+const (
+	ProtoHTTP1 = "http/1.1"
+	ProtoHTTP2 = "h2"
+)
+
+/*
+* Struct for a certificate and its private key. Each of them is either the path of a file in PEM
+* or the content in PEM, such as a secret read by file:///path or from an environment variable
+ */
+type Certificate struct {
+	CertFile string `yaml:"cert"`              //the path of the certificate file in PEM, or the certificate in PEM
+	KeyFile  string `yaml:"key" secret:"true"` //the path of the private key file in PEM, or the private key in PEM
+}
+
+//pemPrefix is the beginning of a certificate or key given in PEM instead of the path of a file
+const pemPrefix = "-----BEGIN"
+
+//IsPEM returns true if v is a certificate or key in PEM rather than the path of a file
+func IsPEM(v string) bool {
+	return strings.HasPrefix(strings.TrimSpace(v), pemPrefix)
+}
+
+//privateKeyMarker ends the type of every PEM block of private keys, such as RSA PRIVATE KEY and EC PRIVATE KEY
+const privateKeyMarker = "PRIVATE KEY-----"
+
+//containsPrivateKey returns true if v contains a private key in PEM, so it should be masked wherever it is set
+func containsPrivateKey(v string) bool {
+	return strings.Contains(v, privateKeyMarker)
+}
+
+//pemName returns the name of the certificate or key v for messages, so the content of a key is never printed
+func pemName(v string) string {
+	if IsPEM(v) {
+		return "(inline PEM)"
+	}
+
+	return v
+}
+
+//Name returns the name of the certificate for messages
+func (c Certificate) Name() string {
+	return pemName(c.CertFile)
+}
+
+/*
+* LoadKeyPair loads the certificate and private key of c, from the files or from the PEM content.
+* the error does not contain the content of the key
+ */
+func LoadKeyPair(c Certificate) (tls.Certificate, error) {
+	certPEM, keyPEM := []byte(c.CertFile), []byte(c.KeyFile)
+	var err error
+	if !IsPEM(c.CertFile) {
+		if certPEM, err = ioutil.ReadFile(c.CertFile); err != nil {
+			return tls.Certificate{}, fmt.Errorf("load certificate %s error: %s", c.CertFile, err)
+		}
+	}
+	if !IsPEM(c.KeyFile) {
+		if keyPEM, err = ioutil.ReadFile(c.KeyFile); err != nil {
+			return tls.Certificate{}, fmt.Errorf("load key %s error: %s", c.KeyFile, err)
+		}
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("load certificate %s and key %s error: %s", pemName(c.CertFile), pemName(c.KeyFile), err)
+	}
+
+	return cert, nil
+}
+
+/*
+* Struct for TLS settings of a listener.
+* The certificate is selected by the server name of SNI from cert and certificates.
+* cert is used if no certificate matches the server name
+ */
+type listenerTLS struct {
+	CertFile     string        `yaml:"cert"`              //the path of the default certificate file in PEM
+	KeyFile      string        `yaml:"key" secret:"true"` //the path of the private key file of the default certificate in PEM
+	Certificates []Certificate `yaml:"certificates"`      //more certificates selected by SNI
+	MinVersion   string        `yaml:"minversion"`        //the minimum TLS version: 1.0, 1.1, 1.2 or 1.3. 1.2 is the default
+	CipherSuites []string      `yaml:"ciphersuites"`      //the names of cipher suites, such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	ALPN         []string      `yaml:"alpn"`              //the protocols for ALPN. It is got from the protocols of the listener if it is empty
+
+	ClientAuth string `yaml:"clientauth"` //the mode of verifying client certificates: request, require or verify-if-given. Client certificates are not requested if it is empty
+	ClientCA   string `yaml:"clientca"`   //the path of the CA bundle in PEM for verifying client certificates
+	CRL        string `yaml:"crl"`        //the path of the CRL file in PEM or DER issued by one of the CAs in clientca
+}
+
+//Struct for a listener in server block
+type Listener struct {
+	Name      string      `yaml:"name"`      //the name of the listener. The address is used if it is empty. It matches FileDescriptorName= of systemd socket
+	Address   string      `yaml:"address"`   //such as 0.0.0.0:8080, [::]:8080 or unix:/run/sysadm.sock
+	Mode      string      `yaml:"mode"`      //the mode of the socket file of a unix domain socket listener, such as 0660
+	Owner     string      `yaml:"owner"`     //the owner of the socket file of a unix domain socket listener, in form of user[:group]
+	Protocols []string    `yaml:"protocols"` //the protocols can be served. http/1.1 and h2 are served if it is empty
+	TLS       listenerTLS `yaml:"tls"`       //TLS is enabled if cert and key are set
+
+	RedirectHTTPS int  `yaml:"redirecthttps"` //redirect all requests to https on this port if it is not zero
+	H2C           bool `yaml:"h2c"`           //serve HTTP/2 over cleartext as well as HTTP/1.1. It can not be enabled with TLS
+	MaxConns      int  `yaml:"maxconns"`      //the maximum number of concurrent connections. 0 for no limit
+}
+
+//IsUnix returns true if l is a unix domain socket listener
+func (l *Listener) IsUnix() bool {
+	return strings.HasPrefix(l.Address, unixPrefix)
+}
+
+//Network returns the network and address for net.Listen
+func (l *Listener) Network() (network string, address string) {
+	if l.IsUnix() {
+		return "unix", strings.TrimPrefix(l.Address, unixPrefix)
+	}
+
+	return "tcp", l.Address
+}
+
+//TLSEnabled returns true if TLS is enabled on l
+func (l *Listener) TLSEnabled() bool {
+	return l.TLS.CertFile != "" || l.TLS.KeyFile != ""
+}
+
+//Certificates returns the default certificate and the certificates selected by SNI of l
+func (l *Listener) Certificates() []Certificate {
+	if !l.TLSEnabled() {
+		return nil
+	}
+
+	return append([]Certificate{{CertFile: l.TLS.CertFile, KeyFile: l.TLS.KeyFile}}, l.TLS.Certificates...)
+}
+
+//HasProtocol returns true if proto can be served by l
+func (l *Listener) HasProtocol(proto string) bool {
+	if len(l.Protocols) == 0 {
+		return proto == ProtoHTTP1 || proto == ProtoHTTP2
+	}
+
+	for _, p := range l.Protocols {
+		if strings.EqualFold(p, proto) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+* checkListeners checks the listeners in server block. If there is not any listener, a listener
+* on server.listen and server.port is added, so the server always serves the listeners.
+* server.listen and server.port have been checked before the listener is added
+ */
+func (settings *Configs) checkListeners() {
+	if len(settings.Server.Listeners) == 0 {
+		address := net.JoinHostPort(settings.Server.Listen, strconv.Itoa(settings.Server.Port))
+		settings.Server.Listeners = []Listener{{Name: address, Address: address}}
+		settings.applyDefault("server.listeners", address)
+		return
+	}
+
+	names := make(map[string]bool)
+	addresses := make(map[string]bool)
+	for i := range settings.Server.Listeners {
+		l := &settings.Server.Listeners[i]
+		fieldPath := fmt.Sprintf("server.listeners[%d]", i)
+
+		if l.Name == "" {
+			l.Name = l.Address
+		}
+		if names[l.Name] {
+			settings.addError(fieldPath+".name", "The name of listener:%s is duplicated", l.Name)
+		}
+		names[l.Name] = true
+
+		if addresses[l.Address] {
+			settings.addError(fieldPath+".address", "The address of listener:%s is duplicated", l.Address)
+		}
+		addresses[l.Address] = true
+
+		if l.IsUnix() {
+			settings.checkUnixListener(fieldPath, l)
+		} else {
+			settings.checkTCPListener(fieldPath, l)
+		}
+
+		for _, p := range l.Protocols {
+			if !strings.EqualFold(p, ProtoHTTP1) && !strings.EqualFold(p, ProtoHTTP2) {
+				settings.addError(fieldPath+".protocols", "The protocol:%s is invalid", p)
+			}
+		}
+
+		settings.checkListenerTLS(fieldPath+".tls", l)
+
+		if l.RedirectHTTPS < 0 || l.RedirectHTTPS > 65535 {
+			settings.addError(fieldPath+".redirecthttps", "The port:%d is invalid. The valid port number is between 1 and 65535!", l.RedirectHTTPS)
+		}
+		if l.RedirectHTTPS > 0 && l.TLSEnabled() {
+			settings.addError(fieldPath+".redirecthttps", "A listener with TLS can not redirect to https")
+		}
+
+		if l.MaxConns < 0 {
+			settings.addError(fieldPath+".maxconns", "The maximum number of connections:%d should not be negative", l.MaxConns)
+		}
+
+		if l.H2C && l.TLSEnabled() {
+			settings.addError(fieldPath+".h2c", "h2c can not be enabled on a listener with TLS")
+		}
+		if l.H2C && !l.HasProtocol(ProtoHTTP2) {
+			settings.addError(fieldPath+".h2c", "h2 should be in protocols when h2c is enabled")
+		}
+	}
+}
+
+//checkTCPListener checks the address of a TCP listener, which should be ip:port or [ipv6]:port
+func (settings *Configs) checkTCPListener(fieldPath string, l *Listener) {
+	host, port, err := net.SplitHostPort(l.Address)
+	if err != nil {
+		settings.addError(fieldPath+".address", "The address:%s is invalid: %s", l.Address, err)
+		return
+	}
+
+	if host != "" && net.ParseIP(host) == nil {
+		settings.addError(fieldPath+".address", "The IP:%s of address %s is invalid", host, l.Address)
+	}
+
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		settings.addError(fieldPath+".address", "The port:%s of address %s is invalid. The valid port number is between 1 and 65535!", port, l.Address)
+	}
+
+	if l.Mode != "" || l.Owner != "" {
+		settings.addError(fieldPath, "mode and owner can only be set for unix domain socket listener")
+	}
+}
+
+//checkUnixListener checks the path, mode and owner of a unix domain socket listener
+func (settings *Configs) checkUnixListener(fieldPath string, l *Listener) {
+	_, socketPath := l.Network()
+	info, err := os.Stat(socketPath)
+	switch {
+	case !filepath.IsAbs(socketPath):
+		settings.addError(fieldPath+".address", "The path of unix socket:%s should be absolute", socketPath)
+	case err == nil && info.Mode()&os.ModeSocket != 0:
+		//the stale socket file will be removed when binding
+	default:
+		settings.checkWritableFile(fieldPath+".address", socketPath)
+	}
+
+	if l.Mode != "" {
+		if _, err := parseDirMode(l.Mode); err != nil {
+			settings.addError(fieldPath+".mode", "The mode:%s is invalid. It should be a octal number such as 0660", l.Mode)
+		}
+	}
+
+	if l.Owner != "" {
+		userName, groupName := SplitOwner(l.Owner)
+		if userName != "" {
+			if _, err := user.Lookup(userName); err != nil {
+				settings.addError(fieldPath+".owner", "%s", err)
+			}
+		}
+		if groupName != "" {
+			if _, err := user.LookupGroup(groupName); err != nil {
+				settings.addError(fieldPath+".owner", "%s", err)
+			}
+		}
+	}
+}
+
+//SplitOwner splits owner in form of user[:group] to user and group
+func SplitOwner(owner string) (userName string, groupName string) {
+	parts := strings.SplitN(owner, ":", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+
+	return parts[0], ""
+}
+
+//ParseMode parses a octal mode string such as 0660
+func ParseMode(mode string) (os.FileMode, error) {
+	return parseDirMode(mode)
+}
+
+//Modes of verifying client certificates
+const (
+	ClientAuthRequest       = "request"         //client certificates are requested, and verified only if clientca is set. The handshake never fails for them
+	ClientAuthRequire       = "require"         //a valid client certificate is required
+	ClientAuthVerifyIfGiven = "verify-if-given" //client certificates are verified if they are given
+)
+
+//ClientAuthType returns the tls.ClientAuthType for the mode of verifying client certificates
+func ClientAuthType(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "":
+		return tls.NoClientCert, nil
+	case ClientAuthRequest:
+		return tls.RequestClientCert, nil
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	case ClientAuthVerifyIfGiven:
+		return tls.VerifyClientCertIfGiven, nil
+	}
+
+	return tls.NoClientCert, fmt.Errorf("The mode of client auth:%s is invalid. It should be one of request, require and verify-if-given", mode)
+}
+
+//LoadClientCA reads the CA certificates in PEM from file
+func LoadClientCA(file string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var cas []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		cas = append(cas, ca)
+	}
+
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("no certificate was found in %s", file)
+	}
+
+	return cas, nil
+}
+
+/*
+* LoadCRL reads the CRL in PEM or DER from file and checks its signature by the CA which issued it in cas.
+* the CA which issued the CRL is returned too
+ */
+func LoadCRL(file string, cas []*x509.Certificate) (*pkix.CertificateList, *x509.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	crl, err := x509.ParseDERCRL(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var issuer pkix.Name
+	issuer.FillFromRDNSequence(&crl.TBSCertList.Issuer)
+	for _, ca := range cas {
+		if ca.Subject.String() == issuer.String() {
+			if err = ca.CheckCRLSignature(crl); err != nil {
+				return nil, nil, err
+			}
+			return crl, ca, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("the issuer of %s is not in the client CAs", file)
+}
+
+//TLS versions can be used as minversion
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//TLSVersion returns the TLS version for minversion. TLS 1.2 is returned if minversion is empty
+func TLSVersion(minversion string) (uint16, error) {
+	if minversion == "" {
+		return tls.VersionTLS12, nil
+	}
+
+	v, found := tlsVersions[minversion]
+	if !found {
+		return 0, fmt.Errorf("The TLS version:%s is invalid. It should be one of 1.0, 1.1, 1.2 and 1.3", minversion)
+	}
+
+	return v, nil
+}
+
+//CipherSuiteIDs returns the IDs of the cipher suites which names are in names
+func CipherSuiteIDs(names []string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[cs.Name] = cs.ID
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		id, found := suites[name]
+		if !found {
+			return nil, fmt.Errorf("The cipher suite:%s is unknown", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+//checkListenerTLS checks the TLS settings of a listener and loads the certificates for validating them
+func (settings *Configs) checkListenerTLS(fieldPath string, l *Listener) {
+	if (l.TLS.CertFile == "") != (l.TLS.KeyFile == "") {
+		settings.addError(fieldPath, "Both of cert and key should be set for enabling TLS")
+		return
+	}
+
+	if !l.TLSEnabled() {
+		if len(l.TLS.Certificates) > 0 {
+			settings.addError(fieldPath+".certificates", "The default cert and key should be set when certificates are set")
+		}
+		if l.TLS.ClientAuth != "" || l.TLS.ClientCA != "" {
+			settings.addError(fieldPath, "The default cert and key should be set for verifying client certificates")
+		}
+		return
+	}
+
+	for i, c := range l.Certificates() {
+		certPath := fieldPath
+		if i > 0 {
+			certPath = fmt.Sprintf("%s.certificates[%d]", fieldPath, i-1)
+		}
+		if _, err := LoadKeyPair(c); err != nil {
+			settings.addError(certPath, "%s", err)
+		}
+	}
+
+	if _, err := TLSVersion(l.TLS.MinVersion); err != nil {
+		settings.addError(fieldPath+".minversion", "%s", err)
+	}
+
+	if _, err := CipherSuiteIDs(l.TLS.CipherSuites); err != nil {
+		settings.addError(fieldPath+".ciphersuites", "%s", err)
+	}
+
+	settings.checkClientAuth(fieldPath, l)
+}
+
+//checkClientAuth checks the settings of verifying client certificates of a listener
+func (settings *Configs) checkClientAuth(fieldPath string, l *Listener) {
+	if _, err := ClientAuthType(l.TLS.ClientAuth); err != nil {
+		settings.addError(fieldPath+".clientauth", "%s", err)
+		return
+	}
+
+	if l.TLS.ClientCA == "" {
+		if l.TLS.ClientAuth == ClientAuthRequire || l.TLS.ClientAuth == ClientAuthVerifyIfGiven {
+			settings.addError(fieldPath+".clientca", "clientca should be set when clientauth is %s", l.TLS.ClientAuth)
+		}
+		if l.TLS.CRL != "" {
+			settings.addError(fieldPath+".crl", "clientca should be set when crl is set")
+		}
+		return
+	}
+
+	if l.TLS.ClientAuth == "" {
+		settings.addError(fieldPath+".clientauth", "clientauth should be set when clientca is set")
+	}
+
+	cas, err := LoadClientCA(l.TLS.ClientCA)
+	if err != nil {
+		settings.addError(fieldPath+".clientca", "Load client CA %s error: %s", l.TLS.ClientCA, err)
+		return
+	}
+
+	if l.TLS.CRL != "" {
+		if _, _, err = LoadCRL(l.TLS.CRL, cas); err != nil {
+			settings.addError(fieldPath+".crl", "Load CRL %s error: %s", l.TLS.CRL, err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified May 04 2021
+**/
+
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+* Matching modes of locations. The location for a request is selected in order of:
+* the exact location, the first regex location in config order, and then the longest prefix location
+ */
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+	MatchRegex  = "regex"
+)
+
+//Handler types of locations
+const (
+	HandlerStatic   = "static"   //serve the static files under root or alias
+	HandlerProxy    = "proxy"    //proxy the requests to the upstream server
+	HandlerRedirect = "redirect" //redirect the requests to the target
+	HandlerReturn   = "return"   //return the status and body
+)
+
+//Struct for the authentication of a location. The requests pass if all of the configured checks pass
+type LocationAuth struct {
+	Users      map[string]string `yaml:"users" secret:"true"` //the bcrypt hashes of the passwords of HTTP basic authentication. key is the user name
+	Realm      string            `yaml:"realm"`               //the realm of HTTP basic authentication
+	ClientCert bool              `yaml:"clientcert"`          //require a verified client certificate
+}
+
+//Struct for a location block in server block
+type Location struct {
+	Path    string `yaml:"path"`    //the path of exact and prefix locations, or the regular expression of regex locations
+	Match   string `yaml:"match"`   //exact, prefix or regex. prefix by default
+	Handler string `yaml:"handler"` //static, proxy, redirect or return. static by default
+
+	Root  string   `yaml:"root"`  //the root of static files which the path of the request is appended to. server.root is used if both root and alias are empty
+	Alias string   `yaml:"alias"` //the directory replacing the path of a prefix location in the path of the request
+	Index []string `yaml:"index"` //the index files. server.index is used if it is empty
+
+	Autoindex   *Autoindex   `yaml:"autoindex"`   //the directory listing of the location. server.autoindex is used if it is not set
+	Compression *Compression `yaml:"compression"` //the compression of the responses of the location. server.compression is used if it is not set
+
+	Headers      map[string]string `yaml:"headers"`      //the headers added to the responses
+	Auth         LocationAuth      `yaml:"auth"`         //the authentication of the requests
+	CacheControl []CacheRule       `yaml:"cachecontrol"` //the Cache-Control rules. server.cachecontrol is used if it is empty
+
+	Proxy    string `yaml:"proxy"`    //the URL of the upstream server of proxy handler, such as http://127.0.0.1:9000
+	Redirect string `yaml:"redirect"` //the target of redirect handler. $1 or ${name} is replaced with the captures of a regex location
+	Status   int    `yaml:"status"`   //the status code of redirect handler (302 by default) or return handler (200 by default)
+	Body     string `yaml:"body"`     //the body of return handler
+}
+
+//Define the default realm of HTTP basic authentication
+const defaultRealm = "Restricted"
+
+//checkLocations checks the location blocks in locationsPath and sets the default values for the settings not set
+func (settings *Configs) checkLocations(locationsPath string, locations []Location) {
+	paths := make(map[string]bool)
+	for i := range locations {
+		l := &locations[i]
+		fieldPath := fmt.Sprintf("%s[%d]", locationsPath, i)
+
+		if l.Match == "" {
+			l.Match = MatchPrefix
+			settings.applyDefault(fieldPath+".match", l.Match)
+		}
+		switch l.Match {
+		case MatchExact, MatchPrefix:
+			if !strings.HasPrefix(l.Path, "/") {
+				settings.addError(fieldPath+".path", "The path:%s should start with /", l.Path)
+			}
+		case MatchRegex:
+			if _, err := regexp.Compile(l.Path); err != nil || l.Path == "" {
+				settings.addError(fieldPath+".path", "The regular expression:%s is invalid: %v", l.Path, err)
+			}
+		default:
+			settings.addError(fieldPath+".match", "The matching mode:%s is invalid. It should be exact, prefix or regex", l.Match)
+		}
+		if key := l.Match + " " + l.Path; paths[key] {
+			settings.addError(fieldPath+".path", "The %s location:%s is duplicated", l.Match, l.Path)
+		} else {
+			paths[key] = true
+		}
+
+		if l.Handler == "" {
+			l.Handler = HandlerStatic
+			settings.applyDefault(fieldPath+".handler", l.Handler)
+		}
+		switch l.Handler {
+		case HandlerStatic:
+			settings.checkLocationStatic(fieldPath, l)
+		case HandlerProxy:
+			if u, err := url.Parse(l.Proxy); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				settings.addError(fieldPath+".proxy", "The upstream URL:%s is invalid. It should be an http or https URL", l.Proxy)
+			}
+		case HandlerRedirect:
+			if l.Redirect == "" {
+				settings.addError(fieldPath+".redirect", "The target of redirect should not be empty")
+			}
+			if l.Status == 0 {
+				l.Status = http.StatusFound
+				settings.applyDefault(fieldPath+".status", l.Status)
+			}
+			if l.Status != http.StatusMovedPermanently && l.Status != http.StatusFound && l.Status != http.StatusSeeOther &&
+				l.Status != http.StatusTemporaryRedirect && l.Status != http.StatusPermanentRedirect {
+				settings.addError(fieldPath+".status", "The status:%d of redirect is invalid. It should be 301, 302, 303, 307 or 308", l.Status)
+			}
+		case HandlerReturn:
+			if l.Status == 0 {
+				l.Status = http.StatusOK
+				settings.applyDefault(fieldPath+".status", l.Status)
+			}
+			if l.Status < 100 || l.Status > 599 {
+				settings.addError(fieldPath+".status", "The status:%d is invalid. It should be between 100 and 599", l.Status)
+			}
+		default:
+			settings.addError(fieldPath+".handler", "The handler:%s is invalid. It should be static, proxy, redirect or return", l.Handler)
+		}
+
+		for name := range l.Headers {
+			if name == "" || strings.ContainsAny(name, " :\t\r\n") {
+				settings.addError(fieldPath+".headers", "The header name:%q is invalid", name)
+			}
+		}
+
+		for user, hash := range l.Auth.Users {
+			if _, err := bcrypt.Cost([]byte(hash)); err != nil || user == "" || strings.Contains(user, ":") {
+				settings.addError(fieldPath+".auth.users", "The user:%s should have a bcrypt hash of the password", user)
+			}
+		}
+		if len(l.Auth.Users) > 0 && l.Auth.Realm == "" {
+			l.Auth.Realm = defaultRealm
+			settings.applyDefault(fieldPath+".auth.realm", l.Auth.Realm)
+		}
+
+		settings.checkCacheRules(fieldPath+".cachecontrol", l.CacheControl)
+		if l.Compression != nil {
+			settings.checkCompression(fieldPath+".compression", l.Compression)
+		}
+	}
+}
+
+//checkLocationStatic checks the root and alias of the static location l
+func (settings *Configs) checkLocationStatic(fieldPath string, l *Location) {
+	if l.Root != "" && l.Alias != "" {
+		settings.addError(fieldPath+".alias", "Only one of root and alias can be set")
+	}
+	if l.Alias != "" && l.Match != MatchPrefix {
+		settings.addError(fieldPath+".alias", "The alias can only be set for a prefix location")
+	}
+
+	if l.Root != "" && !path.IsAbs(l.Root) {
+		l.Root = path.Join(DefaultAppSettings.Prefix, l.Root)
+	}
+	if l.Alias != "" && !path.IsAbs(l.Alias) {
+		l.Alias = path.Join(DefaultAppSettings.Prefix, l.Alias)
+	}
+}
@@ -0,0 +1,250 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 16 2021
+**/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Sources of the value of a field. The latter overrides the former
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+//Struct for a field which can be overrode by environment variable or command line flag
+type Override struct {
+	Path string //YAML path of the field, such as server.port
+	Env  string //Name of the environment variable, such as SYSADM_SERVER_PORT
+	Flag string //Name of the command line flag, such as server.port
+}
+
+//Struct for the effective value of a field and where the value come from
+type FieldSource struct {
+	Path   string
+	Value  string
+	Source string //one of SourceDefault,SourceFile,SourceEnv and SourceFlag. empty if the field has not been set
+}
+
+//EnvPrefix returns the prefix of the environment variables, such as SYSADM_
+func EnvPrefix() string {
+	return strings.ToUpper(DefaultAppSettings.Progname) + "_"
+}
+
+/*
+* Overrides returns all of the fields which can be overrode by environment variables or command line flags.
+* the app block is not included, it is set when building.
+ */
+func Overrides() (overrides []Override) {
+	walkFields(reflect.ValueOf(&Configs{}).Elem(), "", func(fieldPath string, f reflect.StructField, v reflect.Value) {
+		overrides = append(overrides, Override{
+			Path: fieldPath,
+			Env:  EnvPrefix() + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(fieldPath)),
+			Flag: fieldPath,
+		})
+	})
+
+	return overrides
+}
+
+/*
+* ApplyEnv overrides the settings with the environment variables which name is the upper case of
+* the path of a field with EnvPrefix. such as SYSADM_SERVER_PORT for server.port.
+* it should be called after ParseConfig and before ApplyFlags
+ */
+func (settings *Configs) ApplyEnv() error {
+	values := make(map[string]string)
+	for _, o := range Overrides() {
+		if v, found := os.LookupEnv(o.Env); found {
+			values[o.Path] = v
+		}
+	}
+
+	return settings.applyOverrides(values, SourceEnv)
+}
+
+/*
+* ApplyFlags overrides the settings with the values of command line flags.
+* the key of values is the path of a field, such as server.port
+ */
+func (settings *Configs) ApplyFlags(values map[string]string) error {
+	return settings.applyOverrides(values, SourceFlag)
+}
+
+//applyOverrides sets the values to the fields and records source as the source of them
+func (settings *Configs) applyOverrides(values map[string]string, source string) error {
+	var errs []*FieldError
+
+	walkFields(reflect.ValueOf(settings).Elem(), "", func(fieldPath string, f reflect.StructField, v reflect.Value) {
+		s, found := values[fieldPath]
+		if !found {
+			return
+		}
+		if err := setFieldValue(v, s); err != nil {
+			errs = append(errs, &FieldError{Path: fieldPath, Msg: fmt.Sprintf("%s value %q is invalid: %s", source, s, err)})
+			return
+		}
+		settings.setSource(fieldPath, source)
+	})
+
+	if len(errs) > 0 {
+		return &CheckErrors{File: settings.App.ConFile, Errors: errs}
+	}
+
+	return nil
+}
+
+//setSource records where the value of the field come from
+func (settings *Configs) setSource(fieldPath string, source string) {
+	if settings.Runtime.sources == nil {
+		settings.Runtime.sources = make(map[string]string)
+	}
+	settings.Runtime.sources[fieldPath] = source
+}
+
+/*
+* Sources returns the effective value of every field and where it come from, sorted by path.
+* the values of secrets are masked
+ */
+func (settings *Configs) Sources() (sources []FieldSource) {
+	walkFields(reflect.ValueOf(settings).Elem(), "", func(fieldPath string, f reflect.StructField, v reflect.Value) {
+		value := fieldString(v)
+		if value != "" && (settings.isSecret(fieldPath) || f.Tag.Get("secret") == "true" || containsPrivateKey(value)) {
+			value = secretMask
+		}
+		sources = append(sources, FieldSource{
+			Path:   fieldPath,
+			Value:  value,
+			Source: settings.Runtime.sources[fieldPath],
+		})
+	})
+
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Path < sources[j].Path })
+
+	return sources
+}
+
+/*
+* walkFields calls fn for every scalar field of v which has a yaml tag.
+* a field tagged with secret:"true" is always masked when dumping.
+* nested structs are walked recursively, the app block and fields tagged with "-" are skipped
+ */
+func walkFields(v reflect.Value, prefix string, fn func(fieldPath string, f reflect.StructField, v reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := yamlName(f)
+		if name == "" {
+			continue
+		}
+		if prefix == "" && name == "app" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		fv := v.Field(i)
+		switch {
+		case fv.Kind() == reflect.Struct:
+			walkFields(fv, name, fn)
+		case isScalar(fv):
+			fn(name, f, fv)
+		}
+	}
+}
+
+//isScalar returns true if the value can be set from a string
+func isScalar(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return v.Type().Elem().Kind() == reflect.String
+	}
+
+	return false
+}
+
+/*
+* setFieldValue parses s according to the kind of v and set it to v.
+* a slice of string is set from a comma separated list
+ */
+func setFieldValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+//fieldString returns the string form of the value of a field
+func fieldString(v reflect.Value) string {
+	if v.Kind() == reflect.Slice {
+		return strings.Join(v.Interface().([]string), ",")
+	}
+
+	return fmt.Sprintf("%v", v.Interface())
+}
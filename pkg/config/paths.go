@@ -0,0 +1,132 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 15 2021
+**/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+//Permission bits for the owner. The bits for group and others are got by shifting them
+const (
+	permWrite = 02
+	permExec  = 01
+)
+
+//parseDirMode parses a octal mode string such as 0755 to os.FileMode
+func parseDirMode(mode string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("The dirmode:%s is invalid. It should be a octal number such as 0755", mode)
+	}
+
+	return os.FileMode(m), nil
+}
+
+/*
+* hasPerm checks whether the current process has the permission specified by perm on the file of info.
+* it checks the ownership of the file and then the owner,group or other bits of the mode
+ */
+func hasPerm(info os.FileInfo, perm uint32) bool {
+	euid := os.Geteuid()
+	if euid == 0 {
+		return true
+	}
+
+	mode := uint32(info.Mode().Perm())
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return mode&perm != 0
+	}
+
+	if int(stat.Uid) == euid {
+		return mode&(perm<<6) != 0
+	}
+
+	groups, _ := os.Getgroups()
+	groups = append(groups, os.Getegid())
+	for _, gid := range groups {
+		if uint32(gid) == stat.Gid {
+			return mode&(perm<<3) != 0
+		}
+	}
+
+	return mode&perm != 0
+}
+
+/*
+* checkWritableFile checks whether file can be opened for writing by the current process without
+* creating anything. if file exists, it must be a regular file and writable. otherwise the nearest
+* existing ancestor directory must be writable, so that the missing directories and file can be created
+ */
+func (settings *Configs) checkWritableFile(fieldPath string, file string) {
+	info, err := os.Stat(file)
+	if err == nil {
+		if !info.Mode().IsRegular() {
+			settings.addError(fieldPath, "%s is not a regular file", file)
+			return
+		}
+		if !hasPerm(info, permWrite) {
+			settings.addError(fieldPath, "%s is not writable by uid %d", file, os.Geteuid())
+		}
+		return
+	}
+
+	if !os.IsNotExist(err) {
+		settings.addError(fieldPath, "%s", err)
+		return
+	}
+
+	dir := filepath.Dir(file)
+	for {
+		info, err = os.Stat(dir)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			settings.addError(fieldPath, "%s", err)
+			return
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			settings.addError(fieldPath, "no existing parent directory for %s", file)
+			return
+		}
+		dir = parent
+	}
+
+	if !info.IsDir() {
+		settings.addError(fieldPath, "%s is not a directory", dir)
+		return
+	}
+
+	if !hasPerm(info, permWrite) || !hasPerm(info, permExec) {
+		settings.addError(fieldPath, "directory %s is not writable by uid %d", dir, os.Geteuid())
+	}
+}
+
+//createParentDir creates the parent directory of file with mode if it is not exist
+func (settings *Configs) createParentDir(fieldPath string, file string, mode os.FileMode) {
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, mode); err != nil {
+		settings.addError(fieldPath, "Create directory %s error: %s", dir, err)
+	}
+}
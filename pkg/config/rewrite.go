@@ -0,0 +1,118 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified May 05 2021
+**/
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+//Flags of rewrite rules
+const (
+	RewriteInternal = "internal" //rewrite the path and continue with the next rule
+	RewriteLast     = "last"     //rewrite the path and run the rules again from the first one with the new path
+	RewriteBreak    = "break"    //rewrite the path and stop running the rules
+)
+
+//Types of the conditions of rewrite rules
+const (
+	ConditionHost   = "host"   //the host of the request without port
+	ConditionHeader = "header" //the value of the header name
+	ConditionMethod = "method" //the method of the request
+	ConditionQuery  = "query"  //the value of the query parameter name, or the whole query string if name is empty
+)
+
+//Struct for a condition of a rewrite rule
+type RewriteCondition struct {
+	Type    string `yaml:"type"`    //host, header, method or query
+	Name    string `yaml:"name"`    //the name of the header or query parameter
+	Pattern string `yaml:"pattern"` //the regular expression matching the value
+	Negate  bool   `yaml:"negate"`  //the condition passes if the pattern does not match
+}
+
+/*
+* Struct for a rewrite rule. The path of the request is rewritten with replacement if it matches the regular
+* expression and all of the conditions pass. $1 or ${name} in replacement is replaced with the captures.
+* the query string in replacement is prepended to the query of the request, and the query of the request
+* is dropped if replacement ends with ?
+ */
+type RewriteRule struct {
+	Match       string             `yaml:"match"`       //the regular expression matching the path of the request
+	Replacement string             `yaml:"replacement"` //the new path, or the target of a redirect
+	Flag        string             `yaml:"flag"`        //internal, last, break, or the status code of redirect: 301, 302, 307 or 308. internal by default
+	Conditions  []RewriteCondition `yaml:"conditions"`  //all of them should pass
+}
+
+//Struct for the rewrite rules which run before routing
+type Rewrite struct {
+	Debug bool          `yaml:"debug"` //log every rewrite to the error log
+	Rules []RewriteRule `yaml:"rules"`
+}
+
+//IsRedirect returns true if the rule redirects the request
+func (r RewriteRule) IsRedirect() bool {
+	return r.Flag != RewriteInternal && r.Flag != RewriteLast && r.Flag != RewriteBreak
+}
+
+//RedirectStatus returns the status code of a redirect rule
+func (r RewriteRule) RedirectStatus() int {
+	code, _ := strconv.Atoi(r.Flag)
+	return code
+}
+
+//checkRewrite checks the rewrite rules in fieldPath and sets the default values for the settings not set
+func (settings *Configs) checkRewrite(fieldPath string, rw *Rewrite) {
+	for i := range rw.Rules {
+		r := &rw.Rules[i]
+		rulePath := fmt.Sprintf("%s.rules[%d]", fieldPath, i)
+
+		if _, err := regexp.Compile(r.Match); err != nil || r.Match == "" {
+			settings.addError(rulePath+".match", "The regular expression:%s is invalid: %v", r.Match, err)
+		}
+		if r.Replacement == "" {
+			settings.addError(rulePath+".replacement", "The replacement should not be empty")
+		}
+
+		if r.Flag == "" {
+			r.Flag = RewriteInternal
+			settings.applyDefault(rulePath+".flag", r.Flag)
+		}
+		switch r.Flag {
+		case RewriteInternal, RewriteLast, RewriteBreak, "301", "302", "307", "308":
+		default:
+			settings.addError(rulePath+".flag", "The flag:%s is invalid. It should be internal, last, break, 301, 302, 307 or 308", r.Flag)
+		}
+
+		for j, c := range r.Conditions {
+			condPath := fmt.Sprintf("%s.conditions[%d]", rulePath, j)
+			switch c.Type {
+			case ConditionHost, ConditionMethod, ConditionQuery:
+			case ConditionHeader:
+				if c.Name == "" {
+					settings.addError(condPath+".name", "The name of the header should not be empty")
+				}
+			default:
+				settings.addError(condPath+".type", "The type:%s is invalid. It should be host, header, method or query", c.Type)
+			}
+			if _, err := regexp.Compile(c.Pattern); err != nil {
+				settings.addError(condPath+".pattern", "The regular expression:%s is invalid: %s", c.Pattern, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 30 2021
+**/
+
+package config
+
+import (
+	"mime"
+	"strings"
+)
+
+/*
+* Struct for the settings of directory listing. The directory is listed when there is not
+* any index file in it and enable is true. Hiding the files which names start with a dot only
+* affects the listings, they are still served when they are requested. A location returning 404
+* should be used for denying them
+ */
+type Autoindex struct {
+	Enable     bool `yaml:"enable"`
+	ShowHidden bool `yaml:"showhidden"` //list the files which names start with a dot. They are hidden by default
+	ExactSize  bool `yaml:"exactsize"`  //show sizes in bytes instead of KiB, MiB and GiB in HTML
+	LocalTime  bool `yaml:"localtime"`  //show modification times in local time instead of UTC in HTML
+}
+
+//Content codings supported by compression
+const (
+	EncodingGzip   = "gzip"
+	EncodingBrotli = "br"
+	EncodingZstd   = "zstd" //only for precompressed files
+)
+
+/*
+* Struct for the settings of compression. The precompressed siblings of a static file, such as
+* file.br, file.zst and file.gz, are served if precompressed is true. The other responses are
+* compressed on the fly if enable is true
+ */
+type Compression struct {
+	Enable        bool     `yaml:"enable"`
+	Precompressed bool     `yaml:"precompressed"`
+	Encodings     []string `yaml:"encodings"` //the codings used on the fly in order of preference: br and gzip
+	MinSize       int      `yaml:"minsize"`   //the responses smaller than it are not compressed
+	Types         []string `yaml:"types"`     //the MIME types can be compressed, such as text/html or text/*
+	Level         int      `yaml:"level"`     //the compression level between 1 (fastest) and 9 (best)
+}
+
+//Define default value for compression settings
+var defaultCompression = Compression{
+	Encodings: []string{EncodingBrotli, EncodingGzip},
+	MinSize:   1024,
+	Types: []string{"text/*", "application/javascript", "application/json", "application/xml",
+		"application/wasm", "image/svg+xml"},
+	Level: 5,
+}
+
+//checkCompression checks the compression settings in fieldPath and sets the default values for the settings not set
+func (settings *Configs) checkCompression(fieldPath string, c *Compression) {
+	if len(c.Encodings) == 0 {
+		c.Encodings = defaultCompression.Encodings
+		settings.applyDefault(fieldPath+".encodings", c.Encodings)
+	}
+	for _, e := range c.Encodings {
+		if e != EncodingGzip && e != EncodingBrotli {
+			settings.addError(fieldPath+".encodings", "The encoding:%s can not be used on the fly. It should be br or gzip", e)
+		}
+	}
+
+	if c.MinSize == 0 {
+		c.MinSize = defaultCompression.MinSize
+		settings.applyDefault(fieldPath+".minsize", c.MinSize)
+	}
+	if c.MinSize < 0 {
+		settings.addError(fieldPath+".minsize", "The minimum size:%d should not be negative", c.MinSize)
+	}
+
+	if len(c.Types) == 0 {
+		c.Types = defaultCompression.Types
+		settings.applyDefault(fieldPath+".types", c.Types)
+	}
+	for _, t := range c.Types {
+		if _, _, err := mime.ParseMediaType(t); err != nil || !strings.Contains(t, "/") {
+			settings.addError(fieldPath+".types", "The MIME type:%s is invalid", t)
+		}
+	}
+
+	if c.Level == 0 {
+		c.Level = defaultCompression.Level
+		settings.applyDefault(fieldPath+".level", c.Level)
+	}
+	if c.Level < 1 || c.Level > 9 {
+		settings.addError(fieldPath+".level", "The compression level:%d is invalid. It should be between 1 and 9", c.Level)
+	}
+}
+
+//The ways of invalidating the cached files
+const (
+	InvalidateMtime = "mtime" //the modification time and size of a file are checked on every hit
+	InvalidateWatch = "watch" //the cached files are watched by inotify
+)
+
+//Struct for the settings of the in-memory cache of static files
+type FileCache struct {
+	Enable       bool   `yaml:"enable"`
+	MaxSize      int64  `yaml:"maxsize"`      //the maximum total bytes of the cached files and their compressed variants
+	MaxFileSize  int64  `yaml:"maxfilesize"`  //the files larger than it are not cached
+	Invalidation string `yaml:"invalidation"` //mtime or watch
+}
+
+//Define default value for the cache of static files
+var defaultFileCache = FileCache{
+	MaxSize:      64 << 20,
+	MaxFileSize:  1 << 20,
+	Invalidation: InvalidateMtime,
+}
+
+//checkFileCache checks the settings of the cache of static files and sets the default values for the settings not set
+func (settings *Configs) checkFileCache() {
+	c := &settings.Server.Cache
+	if c.MaxSize == 0 {
+		c.MaxSize = defaultFileCache.MaxSize
+		settings.applyDefault("server.cache.maxsize", c.MaxSize)
+	}
+	if c.MaxFileSize == 0 {
+		c.MaxFileSize = defaultFileCache.MaxFileSize
+		settings.applyDefault("server.cache.maxfilesize", c.MaxFileSize)
+	}
+	if c.MaxSize < 0 {
+		settings.addError("server.cache.maxsize", "The maximum size of the cache:%d should be positive", c.MaxSize)
+	} else if c.MaxFileSize < 0 || c.MaxFileSize > c.MaxSize {
+		settings.addError("server.cache.maxfilesize", "The maximum file size:%d should be positive and not larger than maxsize:%d", c.MaxFileSize, c.MaxSize)
+	}
+
+	if c.Invalidation == "" {
+		c.Invalidation = defaultFileCache.Invalidation
+		settings.applyDefault("server.cache.invalidation", c.Invalidation)
+	}
+	if c.Invalidation != InvalidateMtime && c.Invalidation != InvalidateWatch {
+		settings.addError("server.cache.invalidation", "The invalidation:%s is invalid. It should be mtime or watch", c.Invalidation)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 22 2021
+**/
+
+package config
+
+import (
+	"fmt"
+	goruntime "runtime"
+)
+
+//Build metadata. They are injected by Makefile with -ldflags "-X"
+var (
+	Commit      string //The last commit when building
+	BuildBranch string //The git branch when building
+	Buildstamp  string //The time of building
+	goversion   string //The output of "go version" when building
+)
+
+//Struct for version information of the software
+type Version struct {
+	Progname   string `json:"progname"`
+	Version    string `json:"version"`
+	Commit     string `json:"commit"`
+	Branch     string `json:"branch"`
+	Buildstamp string `json:"buildstamp"`
+	GoVersion  string `json:"goversion"`
+}
+
+/*
+* VersionInfo returns the version information of the software.
+* the version of go runtime is used if goversion has not been injected
+ */
+func VersionInfo() Version {
+	v := Version{
+		Progname:   DefaultAppSettings.Progname,
+		Version:    DefaultAppSettings.Proversion,
+		Commit:     Commit,
+		Branch:     BuildBranch,
+		Buildstamp: Buildstamp,
+		GoVersion:  goversion,
+	}
+
+	if v.GoVersion == "" {
+		v.GoVersion = goruntime.Version()
+	}
+
+	return v
+}
+
+//String returns the version information in text
+func (v Version) String() string {
+	return fmt.Sprintf("%s version %s\n  commit:     %s\n  branch:     %s\n  build time: %s\n  go version: %s",
+		v.Progname, v.Version, v.Commit, v.Branch, v.Buildstamp, v.GoVersion)
+}
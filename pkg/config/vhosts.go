@@ -0,0 +1,125 @@
+/*
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+* @License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified May 06 2021
+**/
+
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+/*
+* Struct for a name-based virtual host. The requests are dispatched to it by Host or SNI. The server block
+* is the default virtual host for the requests matching no server name unless a virtual host is the default.
+* autoindex, compression, cache, cachecontrol, errorpages and rewrite of the server block are shared by all of them
+ */
+type VHost struct {
+	ServerNames []string    `yaml:"servernames"` //such as example.com, *.example.com or www.example.*
+	Default     bool        `yaml:"default"`     //serve the requests matching no server name
+	RootPath    string      `yaml:"root"`        //server.root is used if it is empty
+	Index       []string    `yaml:"index"`       //the index files. server.index is used if it is empty
+	Locations   []Location  `yaml:"locations"`   //the locations of the virtual host. The locations of server block are not inherited
+	TLS         Certificate `yaml:"tls"`         //the certificate selected by SNI on all of the TLS listeners
+	AccessLog   string      `yaml:"accesslog"`   //log.accesslog is used if it is empty
+	ErrorLog    string      `yaml:"errorlog"`    //log.errorlog is used if it is empty
+}
+
+//ValidServerName returns true if name is a host name, or a wildcard name such as *.example.com or www.example.*
+func ValidServerName(name string) bool {
+	host := name
+	switch {
+	case strings.HasPrefix(name, "*."):
+		host = name[2:]
+	case strings.HasSuffix(name, ".*"):
+		host = name[:len(name)-2]
+	}
+
+	if host == "" || strings.ContainsAny(host, "*:/ ") || strings.HasPrefix(host, ".") || strings.HasSuffix(host, ".") {
+		return false
+	}
+
+	return !strings.Contains(host, "..")
+}
+
+//checkVHosts checks the virtual hosts in server block and sets the default values for the settings not set
+func (settings *Configs) checkVHosts() {
+	names := make(map[string]bool)
+	defaults := 0
+	for i := range settings.Server.VHosts {
+		v := &settings.Server.VHosts[i]
+		fieldPath := fmt.Sprintf("server.vhosts[%d]", i)
+
+		if len(v.ServerNames) == 0 && !v.Default {
+			settings.addError(fieldPath+".servernames", "The server names should not be empty unless the virtual host is the default")
+		}
+		for j, name := range v.ServerNames {
+			name = strings.ToLower(name)
+			v.ServerNames[j] = name
+			if !ValidServerName(name) {
+				settings.addError(fieldPath+".servernames", "The server name:%s is invalid", name)
+			}
+			if names[name] {
+				settings.addError(fieldPath+".servernames", "The server name:%s is duplicated", name)
+			}
+			names[name] = true
+		}
+		if v.Default {
+			defaults++
+		}
+
+		if v.RootPath == "" {
+			v.RootPath = settings.Server.RootPath
+			settings.applyDefault(fieldPath+".root", v.RootPath)
+		}
+		if !path.IsAbs(v.RootPath) {
+			v.RootPath = path.Join(DefaultAppSettings.Prefix, v.RootPath)
+		}
+
+		if len(v.Index) == 0 {
+			v.Index = strings.Fields(settings.Server.Indexs)
+			settings.applyDefault(fieldPath+".index", v.Index)
+		}
+
+		settings.checkLocations(fieldPath+".locations", v.Locations)
+
+		if (v.TLS.CertFile == "") != (v.TLS.KeyFile == "") {
+			settings.addError(fieldPath+".tls", "Both cert and key should be set for TLS")
+		} else if v.TLS.CertFile != "" {
+			if _, err := LoadKeyPair(v.TLS); err != nil {
+				settings.addError(fieldPath+".tls", "%s", err)
+			}
+		}
+
+		if v.AccessLog != "" {
+			if !path.IsAbs(v.AccessLog) {
+				v.AccessLog = path.Join(DefaultAppSettings.Prefix, v.AccessLog)
+			}
+			settings.checkWritableFile(fieldPath+".accesslog", v.AccessLog)
+		}
+		if v.ErrorLog != "" {
+			if !path.IsAbs(v.ErrorLog) {
+				v.ErrorLog = path.Join(DefaultAppSettings.Prefix, v.ErrorLog)
+			}
+			settings.checkWritableFile(fieldPath+".errorlog", v.ErrorLog)
+		}
+	}
+
+	if defaults > 1 {
+		settings.addError("server.vhosts", "Only one virtual host can be the default, got %d", defaults)
+	}
+}
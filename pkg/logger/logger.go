@@ -82,6 +82,19 @@ func New() *SysadmLogger {
 	return &sysadmLogger
 }
 
+/*
+* NewInstance creates a logger independent of the global one, such as for the log files of a virtual host.
+* the formats and the stdout logger are copied from the global logger
+ */
+func NewInstance() *SysadmLogger {
+	return &SysadmLogger{
+		stdoutLogger: sysadmLogger.stdoutLogger,
+		LoggerFormat: sysadmLogger.LoggerFormat,
+		DateFormat:   sysadmLogger.DateFormat,
+		Allstdout:    sysadmLogger.Allstdout,
+	}
+}
+
 /*
 * initated a logger to logging log message to stdout
  */
@@ -20,7 +20,6 @@
 package logger
 
 import (
-	"os"
 	"testing"
 )
 
@@ -59,6 +58,4 @@ func Test_logger(t *testing.T) {
 	sysadmLogger.Allstdout = false
 	sysadmLogger.LoggingLog("error", "error", "This message output to access at allstdout is false")
 	sysadmLogger.LoggingLog("error", "error", "This message output to stdout at allstdout is false")
-
-	os.Exit(0)
 }
@@ -0,0 +1,61 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 22 2021
+**/
+
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wangyysde/bzhyserver/pkg/config"
+)
+
+//The prefix of the paths of admin API
+const adminPrefix = "/admin"
+
+//registerAdminRoutes adds the handlers of admin API to r
+func registerAdminRoutes(r *gin.Engine) {
+	admin := r.Group(adminPrefix)
+	admin.GET("/version", func(c *gin.Context) {
+		c.JSON(http.StatusOK, config.VersionInfo())
+	})
+	admin.GET("/cache", localOnly(), func(c *gin.Context) {
+		if Svr.cache == nil {
+			c.JSON(http.StatusOK, cacheStats{})
+			return
+		}
+		c.JSON(http.StatusOK, Svr.cache.stats())
+	})
+}
+
+/*
+* localOnly allows the requests from the loopback addresses only. The statistics of the cache tells which files
+* are requested, so they are not exposed to others. The address of the connection is checked rather than
+* X-Forwarded-For which can be set by the clients
+ */
+func localOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			abortWithError(c, http.StatusForbidden)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 30 2021
+**/
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//Struct for a file in the listing of a directory
+type dirEntry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+//The columns can be sorted by. ?sort=name|size|mtime&order=asc|desc
+const (
+	sortByName  = "name"
+	sortBySize  = "size"
+	sortByMtime = "mtime"
+	orderDesc   = "desc"
+)
+
+var autoindexTemplate = template.Must(template.New("autoindex").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th><a href="?sort=name&amp;order={{.Orders.name}}">Name</a></th><th><a href="?sort=size&amp;order={{.Orders.size}}">Size</a></th><th><a href="?sort=mtime&amp;order={{.Orders.mtime}}">Modified</a></th></tr>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td>-</td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td>{{.Size}}</td><td>{{.ModTime}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+/*
+* readDirEntries reads the files in dir. The symbolic links are followed, and the broken links and the links
+* to the files out of the root are ignored. the files which names start with a dot are ignored unless showHidden is set
+ */
+func (h *staticHandler) readDirEntries(dir string) ([]dirEntry, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]dirEntry, 0, len(infos))
+	for _, info := range infos {
+		if !h.autoindex.ShowHidden && strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			name := filepath.Join(dir, info.Name())
+			if _, err = h.confine(name); err != nil {
+				continue
+			}
+			if info, err = os.Stat(name); err != nil {
+				continue
+			}
+		}
+
+		entry := dirEntry{Name: info.Name(), IsDir: info.IsDir(), ModTime: info.ModTime().UTC()}
+		if !entry.IsDir {
+			entry.Size = info.Size()
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+//sortEntries sorts entries by the column by. The directories are always before the files
+func sortEntries(entries []dirEntry, by string, desc bool) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if desc {
+			a, b = b, a
+		}
+
+		switch by {
+		case sortBySize:
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case sortByMtime:
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		}
+
+		return a.Name < b.Name
+	})
+}
+
+//formatSize returns size in the form of KiB, MiB or GiB
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit && exp < 2; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMG"[exp])
+}
+
+/*
+* listDirectory sends the listing of dir which is the path of the request. JSON is sent if the client
+* accepts application/json, otherwise HTML is sent
+ */
+func (h *staticHandler) listDirectory(c *gin.Context, dir string) {
+	entries, err := h.readDirEntries(dir)
+	if err != nil {
+		abortWithError(c, http.StatusForbidden)
+		return
+	}
+
+	by := c.Query("sort")
+	if by != sortBySize && by != sortByMtime {
+		by = sortByName
+	}
+	desc := c.Query("order") == orderDesc
+	sortEntries(entries, by, desc)
+
+	urlPath := c.Request.URL.Path
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, gin.H{"path": urlPath, "entries": entries})
+		return
+	}
+
+	type htmlEntry struct {
+		Name, Href, Size, ModTime string
+	}
+	var rows []htmlEntry
+	for _, e := range entries {
+		row := htmlEntry{Name: e.Name, Href: url.PathEscape(e.Name), Size: "-"}
+		if e.IsDir {
+			row.Name += "/"
+			row.Href += "/"
+		} else if h.autoindex.ExactSize {
+			row.Size = fmt.Sprintf("%d", e.Size)
+		} else {
+			row.Size = formatSize(e.Size)
+		}
+		modTime := e.ModTime
+		if h.autoindex.LocalTime {
+			modTime = modTime.Local()
+		}
+		row.ModTime = modTime.Format("2006-01-02 15:04:05")
+		rows = append(rows, row)
+	}
+
+	//the order of a column is reversed when clicking it again
+	orders := map[string]string{sortByName: "asc", sortBySize: "asc", sortByMtime: "asc"}
+	if !desc {
+		orders[by] = orderDesc
+	}
+
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	if err = autoindexTemplate.Execute(c.Writer, gin.H{"Path": urlPath, "Entries": rows, "Orders": orders}); err != nil {
+		c.Error(err)
+	}
+}
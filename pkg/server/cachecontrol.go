@@ -0,0 +1,47 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified May 02 2021
+**/
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/wangyysde/bzhyserver/pkg/config"
+)
+
+/*
+* applyCacheRules sets Cache-Control and Expires of the response for urlPath by the first matching rule.
+* the headers set by the handler or the upstream server are replaced. Nothing is changed if no rule matches
+ */
+func applyCacheRules(header http.Header, rules []config.CacheRule, urlPath string) {
+	for _, r := range rules {
+		if !r.Matches(urlPath) {
+			continue
+		}
+
+		header.Set("Cache-Control", r.Directives)
+		if expires, ok := r.Expires(time.Now()); ok {
+			header.Set("Expires", expires.UTC().Format(http.TimeFormat))
+		} else {
+			header.Del("Expires")
+		}
+		return
+	}
+}
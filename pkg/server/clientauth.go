@@ -0,0 +1,188 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 26 2021
+**/
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//The key of the identity of the verified client certificate in gin.Context
+const clientIdentityKey = "sysadm.clientIdentity"
+
+//The key of the listener which accepted the connection in the context of a request
+type listenerKey struct{}
+
+//Struct for the identity of a verified client certificate
+type clientIdentity struct {
+	Subject        string   `json:"subject"`
+	DNSNames       []string `json:"dnsNames,omitempty"`
+	EmailAddresses []string `json:"emailAddresses,omitempty"`
+	IPAddresses    []string `json:"ipAddresses,omitempty"`
+	URIs           []string `json:"uris,omitempty"`
+}
+
+//newClientIdentity gets the subject and SANs of cert
+func newClientIdentity(cert *x509.Certificate) *clientIdentity {
+	id := &clientIdentity{
+		Subject:        cert.Subject.String(),
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+	}
+	for _, ip := range cert.IPAddresses {
+		id.IPAddresses = append(id.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		id.URIs = append(id.URIs, uri.String())
+	}
+
+	return id
+}
+
+//String returns the subject and the SANs of the identity, such as CN=client (DNS:example.com,email:client@example.com)
+func (id *clientIdentity) String() string {
+	var sans []string
+	for _, name := range id.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+	for _, email := range id.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	for _, ip := range id.IPAddresses {
+		sans = append(sans, "IP:"+ip)
+	}
+	for _, uri := range id.URIs {
+		sans = append(sans, "URI:"+uri)
+	}
+	if len(sans) == 0 {
+		return id.Subject
+	}
+
+	return id.Subject + " (" + strings.Join(sans, ",") + ")"
+}
+
+//clientPool returns the pool of client CAs in use
+func (s *certStore) clientPool() *x509.CertPool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.clientCAs
+}
+
+//checkRevoked returns an error if any of the certificates in chains has been revoked by the CRL
+func (s *certStore) checkRevoked(chains [][]*x509.Certificate) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.crlIssuer == nil {
+		return nil
+	}
+
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if bytes.Equal(cert.RawIssuer, s.crlIssuer) && s.revoked[cert.SerialNumber.String()] {
+				return fmt.Errorf("the certificate %s has been revoked", cert.Subject)
+			}
+		}
+	}
+
+	return nil
+}
+
+//verifyPeerCertificate is called after the client certificate has been verified by crypto/tls
+func (s *certStore) verifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return s.checkRevoked(verifiedChains)
+}
+
+/*
+* verifyClient verifies the client certificates which have been requested but not verified during the handshake,
+* such as in request mode. certs[0] is the certificate of the client and the others are intermediates
+ */
+func (s *certStore) verifyClient(certs []*x509.Certificate) ([][]*x509.Certificate, error) {
+	pool := s.clientPool()
+	if pool == nil {
+		return nil, errors.New("client CA has not been set")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	chains, err := certs[0].Verify(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return chains, s.checkRevoked(chains)
+}
+
+/*
+* verifiedClient returns the identity of the client certificate of r. nil is returned if the client
+* has not sent a certificate or the certificate can not be verified
+ */
+func verifiedClient(r *http.Request) *clientIdentity {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+
+	chains := r.TLS.VerifiedChains
+	if len(chains) == 0 {
+		sl, ok := r.Context().Value(listenerKey{}).(*serverListener)
+		if !ok || sl.certs == nil {
+			return nil
+		}
+		var err error
+		if chains, err = sl.certs.verifyClient(r.TLS.PeerCertificates); err != nil {
+			return nil
+		}
+	}
+
+	return newClientIdentity(chains[0][0])
+}
+
+//clientAuth returns a middleware which sets the identity of the verified client certificate on gin.Context
+func clientAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id := verifiedClient(c.Request); id != nil {
+			c.Set(clientIdentityKey, id)
+		}
+		c.Next()
+	}
+}
+
+//getClientIdentity returns the identity of the verified client certificate or nil if there is not
+func getClientIdentity(c *gin.Context) *clientIdentity {
+	if v, found := c.Get(clientIdentityKey); found {
+		return v.(*clientIdentity)
+	}
+
+	return nil
+}
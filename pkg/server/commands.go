@@ -0,0 +1,108 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 21 2021
+**/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/wangyysde/bzhyserver/pkg/config"
+	"github.com/wangyysde/bzhyserver/pkg/logger"
+)
+
+/*
+* runConfigCheck checks the config file without starting the server.
+* it returns the exit code, which is not zero if the config file is invalid
+ */
+func runConfigCheck(sysadmLogger *logger.SysadmLogger) int {
+	settings, errno := loadConfig(sysadmLogger)
+	if errno > 0 {
+		return errno
+	}
+
+	if err := settings.CheckConfig(); err != nil {
+		logConfigErrors(sysadmLogger, err)
+		return 20001 //Error no: AABBB. AA: file seq,commands is 2; BBB: error no
+	}
+
+	sysadmLogger.LoggingLogf("stdout", "info", "Config file %s is valid", *configFile)
+	return 0
+}
+
+/*
+* runConfigDump prints the effective config with defaults and overrides applied in yaml or json.
+* the source of every field is printed instead if showSources is true. secrets are masked.
+* the problems of config are printed to stderr, so the output can be piped
+ */
+func runConfigDump(sysadmLogger *logger.SysadmLogger, format string, showSources bool) int {
+	settings, errno := loadConfig(sysadmLogger)
+	if errno > 0 {
+		return errno
+	}
+
+	if err := settings.CheckConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	if showSources {
+		return printValue(sysadmLogger, settings.Sources(), format)
+	}
+
+	return printValue(sysadmLogger, settings.Effective(), format)
+}
+
+//runConfigSchema prints the JSON Schema of the config file
+func runConfigSchema(sysadmLogger *logger.SysadmLogger) int {
+	return printValue(sysadmLogger, config.Schema(), "json")
+}
+
+//printValue prints v to stdout in yaml or json
+func printValue(sysadmLogger *logger.SysadmLogger, v interface{}, format string) int {
+	var out []byte
+	var err error
+	if format == "json" {
+		out, err = json.MarshalIndent(v, "", "  ")
+		out = append(out, '\n')
+	} else {
+		out, err = yaml.Marshal(v)
+	}
+
+	if err != nil {
+		sysadmLogger.LoggingLogf("stdout", "error", "Marshal %s error:%s", format, err)
+		return 20002
+	}
+
+	fmt.Fprint(os.Stdout, string(out))
+	return 0
+}
+
+//runVersion prints the version information in text or json
+func runVersion(sysadmLogger *logger.SysadmLogger, format string) int {
+	v := config.VersionInfo()
+	if format == "json" {
+		return printValue(sysadmLogger, v, "json")
+	}
+
+	fmt.Fprintln(os.Stdout, v.String())
+	return 0
+}
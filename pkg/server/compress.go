@@ -0,0 +1,352 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified May 01 2021
+**/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+
+	"github.com/wangyysde/bzhyserver/pkg/config"
+)
+
+//The file extensions of the precompressed siblings in order of preference
+var precompressedExts = []struct {
+	encoding string
+	ext      string
+}{
+	{config.EncodingBrotli, ".br"},
+	{config.EncodingZstd, ".zst"},
+	{config.EncodingGzip, ".gz"},
+}
+
+/*
+* acceptedEncodings parses Accept-Encoding and returns the q-values of the codings. The coding "*" matches
+* any coding which is not listed
+ */
+func acceptedEncodings(header string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[coding] = q
+	}
+
+	return accepted
+}
+
+/*
+* orderedEncodings returns the codings in offered which the client accepts in order of the q-values.
+* the order of offered is kept when the q-values are equal
+ */
+func orderedEncodings(header string, offered []string) []string {
+	accepted := acceptedEncodings(header)
+	qs := make(map[string]float64)
+	var ret []string
+	for _, coding := range offered {
+		q, found := accepted[coding]
+		if !found {
+			q = accepted["*"]
+		}
+		if _, dup := qs[coding]; q > 0 && !dup {
+			qs[coding] = q
+			ret = append(ret, coding)
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return qs[ret[i]] > qs[ret[j]]
+	})
+
+	return ret
+}
+
+//negotiateEncoding returns the coding in offered which the client accepts with the highest q-value, or "" if none is accepted
+func negotiateEncoding(header string, offered []string) string {
+	if codings := orderedEncodings(header, offered); len(codings) > 0 {
+		return codings[0]
+	}
+
+	return ""
+}
+
+//precompressedEncodings returns the codings of precompressed siblings in order of preference
+func precompressedEncodings() []string {
+	var codings []string
+	for _, p := range precompressedExts {
+		codings = append(codings, p.encoding)
+	}
+
+	return codings
+}
+
+//newEncoder returns a writer which compresses the data written to w in encoding
+func newEncoder(w io.Writer, encoding string, level int) io.WriteCloser {
+	if encoding == config.EncodingBrotli {
+		return brotli.NewWriterLevel(w, level)
+	}
+
+	enc, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		enc = gzip.NewWriter(w)
+	}
+
+	return enc
+}
+
+//compressBytes returns data compressed in encoding
+func compressBytes(data []byte, encoding string, level int) []byte {
+	var buf bytes.Buffer
+	enc := newEncoder(&buf, encoding, level)
+	enc.Write(data)
+	enc.Close()
+
+	return buf.Bytes()
+}
+
+//compressibleType returns true if the media type of contentType matches one of types, such as text/html or text/*
+func compressibleType(contentType string, types []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, t := range types {
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//addVary adds value to the Vary header if it is not there
+func addVary(h http.Header, value string) {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), value) {
+				return
+			}
+		}
+	}
+
+	h.Add("Vary", value)
+}
+
+/*
+* Struct for a gin.ResponseWriter which compresses the body on the fly. The body is buffered until
+* there are minsize bytes, so the small responses are not compressed
+ */
+type compressWriter struct {
+	gin.ResponseWriter
+	settings *config.Compression
+	accept   string //the Accept-Encoding header of the request
+
+	decided     bool
+	compressing bool
+	buf         []byte
+	enc         io.WriteCloser
+}
+
+//transformsBody returns true if the body is being compressed, then sendfile can not be used
+func (w *compressWriter) transformsBody() bool {
+	w.decide(-1)
+	return w.compressing
+}
+
+/*
+* decide decides whether the body should be compressed by the status and headers of the response.
+* size is the size of the body, or -1 if it is unknown yet
+ */
+func (w *compressWriter) decide(size int) {
+	if w.decided {
+		return
+	}
+
+	h := w.Header()
+	status := w.Status()
+	if !w.settings.Enable || status != http.StatusOK || h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" ||
+		!compressibleType(h.Get("Content-Type"), w.settings.Types) {
+		w.decided = true
+		return
+	}
+
+	//the response may be compressed for other clients
+	addVary(h, "Accept-Encoding")
+	encoding := negotiateEncoding(w.accept, w.settings.Encodings)
+	if encoding == "" {
+		w.decided = true
+		return
+	}
+
+	if size < 0 {
+		if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil {
+			size = cl
+		}
+	}
+	if size >= 0 && size < w.settings.MinSize {
+		w.decided = true
+		return
+	}
+
+	if size < 0 && len(w.buf) < w.settings.MinSize {
+		//wait for more data
+		return
+	}
+
+	w.decided = true
+	w.compressing = true
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	h.Set("Content-Encoding", encoding)
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		//the compressed body is not byte-for-byte identical to the original one
+		h.Set("ETag", "W/"+etag)
+	}
+
+	w.enc = newEncoder(w.ResponseWriter, encoding, w.settings.Level)
+}
+
+//flushBuffer writes the buffered data after deciding
+func (w *compressWriter) flushBuffer() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	buf := w.buf
+	w.buf = nil
+	var err error
+	if w.compressing {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+
+	return err
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, data...)
+		w.decide(-1)
+		if !w.decided {
+			return len(data), nil
+		}
+		return len(data), w.flushBuffer()
+	}
+
+	if w.compressing {
+		return w.enc.Write(data)
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+//WriteHeaderNow decides by Content-Length before the header is sent
+func (w *compressWriter) WriteHeaderNow() {
+	if !w.decided {
+		w.decide(-1)
+	}
+	if w.decided {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+//Flush sends the buffered data. The body is compressed if it can be, because the size is unknown
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(w.settings.MinSize)
+	}
+	w.flushBuffer()
+	if f, ok := w.enc.(interface{ Flush() error }); ok && w.compressing {
+		f.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+//close decides by the size of the buffered data if it has not decided, and finishes compressing
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.decide(len(w.buf))
+	}
+	w.flushBuffer()
+	if w.compressing {
+		w.enc.Close()
+	}
+}
+
+/*
+* compress returns a middleware which compresses the responses on the fly with the codings accepted by
+* the client. The settings can be replaced by useCompression before the response is written, such as
+* by a location. Nothing is compressed if compression is not enabled
+ */
+func compress(settings *config.Compression) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{
+			ResponseWriter: c.Writer,
+			settings:       settings,
+			accept:         c.Request.Header.Get("Accept-Encoding"),
+		}
+		c.Writer = w
+		defer func() {
+			w.close()
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+	}
+}
+
+//useCompression replaces the compression settings of the response of c if nothing has been written
+func useCompression(c *gin.Context, settings *config.Compression) {
+	if w, ok := c.Writer.(*compressWriter); ok && !w.decided && len(w.buf) == 0 {
+		w.settings = settings
+	}
+}
@@ -0,0 +1,267 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 23 2021
+**/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/wangyysde/bzhyserver/pkg/config"
+)
+
+const (
+	daemonEnv      = "_SYSADM_DAEMON_CHILD" //the environment variable which marks the process is the detached child of --daemon
+	daemonReadyEnv = "_SYSADM_DAEMON_READY" //the fd which the child notifies the parent it is serving through
+	daemonTimeout  = 30 * time.Second       //the parent reports failure if the child is not ready in it
+)
+
+//isDaemonChild returns true if the process has been started by daemonize
+func isDaemonChild() bool {
+	return os.Getenv(daemonEnv) == "1"
+}
+
+/*
+* daemonize starts the program again in a new session with the same arguments, and with stdin,
+* stdout and stderr redirected to /dev/null. The child changes the working directory to / after
+* loading the config. Go can not fork a running process safely, so the child is a new process
+* which knows it is the daemon by daemonEnv. The parent waits until the child notifies it is
+* serving by notifyDaemonReady and returns the pid of the child. An error is returned if the child
+* exits or is not ready in daemonTimeout, so the failures of starting are not reported as success
+ */
+func daemonize() (pid int, err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer null.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	//the fds of cmd.ExtraFiles start at 3 in the child
+	cmd.Env = append(filterEnv(os.Environ(), daemonReadyEnv), daemonEnv+"=1", daemonReadyEnv+"=3")
+	cmd.Stdin = null
+	cmd.Stdout = null
+	cmd.Stderr = null
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+	pid = cmd.Process.Pid
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+		if err == nil {
+			return pid, cmd.Process.Release()
+		}
+		//the pipe is closed without any byte when the child exits
+		if err = cmd.Wait(); err == nil {
+			err = errors.New("exit status 0")
+		}
+		return 0, fmt.Errorf("the daemon %d exited before ready: %s", pid, err)
+	case <-time.After(daemonTimeout):
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("the daemon %d was not ready in %s", pid, daemonTimeout)
+	}
+}
+
+//notifyDaemonReady notifies the parent that the server is serving if it is started by daemonize
+func notifyDaemonReady() error {
+	return notifyReadyFD(daemonReadyEnv)
+}
+
+/*
+* lookupIDs returns the uid and gid of userName and groupName, and the groups which the process should be in.
+* The primary group of userName is used if groupName is empty, and the supplementary groups of userName are kept.
+* -1 is returned for the one which is not set
+ */
+func lookupIDs(userName string, groupName string) (uid int, gid int, groups []int, err error) {
+	uid, gid = -1, -1
+
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return uid, gid, nil, err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return uid, gid, nil, err
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return uid, gid, nil, err
+		}
+		ids, err := u.GroupIds()
+		if err != nil {
+			return uid, gid, nil, err
+		}
+		for _, id := range ids {
+			n, err := strconv.Atoi(id)
+			if err != nil {
+				return uid, gid, nil, err
+			}
+			groups = append(groups, n)
+		}
+	}
+
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return uid, gid, nil, err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return uid, gid, nil, err
+		}
+	}
+
+	if gid >= 0 && !containsID(groups, gid) {
+		groups = append(groups, gid)
+	}
+
+	return uid, gid, groups, nil
+}
+
+//containsID returns true if id is in ids
+func containsID(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+* chownFiles changes the owner of files to uid and gid, so the server can reopen or remove them
+* after dropping privileges
+ */
+func chownFiles(uid int, gid int, files ...string) error {
+	if uid < 0 && gid < 0 {
+		return nil
+	}
+
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		if err := os.Chown(f, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+* preparePidFile returns the path of the pid file which can be removed by uid after dropping privileges.
+* pidFile is returned if uid is not set or owns the directory of it. Otherwise the pid file is put into
+* a directory named by the program in that directory, which is created with mode and owned by uid and gid.
+* The process running as uid, such as the new process of upgrading, uses that directory if it exists
+ */
+func preparePidFile(pidFile string, uid int, gid int, mode os.FileMode) (string, error) {
+	if uid < 0 {
+		return pidFile, nil
+	}
+
+	dir := filepath.Dir(pidFile)
+	if ownedBy(dir, uid) {
+		return pidFile, nil
+	}
+
+	dir = filepath.Join(dir, config.DefaultAppSettings.Progname)
+	if uid == os.Geteuid() {
+		if ownedBy(dir, uid) {
+			return filepath.Join(dir, filepath.Base(pidFile)), nil
+		}
+		return pidFile, nil
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return "", err
+	}
+	if !ownedBy(dir, uid) {
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return "", err
+		}
+	}
+
+	return filepath.Join(dir, filepath.Base(pidFile)), nil
+}
+
+//ownedBy returns true if the owner of the file name is uid
+func ownedBy(name string, uid int) bool {
+	info, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+
+	return ok && int(st.Uid) == uid
+}
+
+/*
+* dropPrivileges changes the groups and user of the process to groups, gid and uid.
+* It should be called after the listen sockets have been bound
+ */
+func dropPrivileges(uid int, gid int, groups []int) error {
+	if len(groups) > 0 {
+		if err := syscall.Setgroups(groups); err != nil {
+			return fmt.Errorf("setgroups %v: %s", groups, err)
+		}
+	}
+
+	if gid >= 0 {
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid %d: %s", gid, err)
+		}
+	}
+
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid %d: %s", uid, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified May 03 2021
+**/
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wangyysde/bzhyserver/pkg/config"
+	"github.com/wangyysde/bzhyserver/pkg/logger"
+)
+
+//The key of the error pages in the context of gin
+const errorPagesKey = "sysadm.errorPages"
+
+//The key of the logger for the errors found while sending error pages in the context of gin
+const errorLoggerKey = "sysadm.errorLogger"
+
+//Struct for the error page of a range of status codes
+type errorPage struct {
+	low  int
+	high int
+	file string
+	tmpl *template.Template //nil if the page is not a template
+}
+
+//Data for executing the templates of error pages
+type errorPageData struct {
+	Status     int
+	StatusText string
+	Method     string
+	Path       string
+}
+
+//errorPages holds the error pages configured. The pages of narrower ranges are preferred
+type errorPages struct {
+	pages []errorPage
+}
+
+/*
+* newErrorPages creates the error pages under root. The templates are parsed here, and the other
+* pages are read when they are sent, so they can be changed without restarting the server
+ */
+func newErrorPages(root string, pages map[string]string) (*errorPages, error) {
+	p := &errorPages{}
+	for key, page := range pages {
+		low, high, err := config.ParseStatusRange(key)
+		if err != nil {
+			return nil, err
+		}
+
+		ep := errorPage{low: low, high: high, file: filepath.Join(root, filepath.FromSlash(path.Clean("/"+page)))}
+		if strings.HasSuffix(ep.file, config.ErrorPageTemplateExt) {
+			if ep.tmpl, err = template.ParseFiles(ep.file); err != nil {
+				return nil, err
+			}
+		}
+		p.pages = append(p.pages, ep)
+	}
+
+	sort.Slice(p.pages, func(i, j int) bool {
+		wi, wj := p.pages[i].high-p.pages[i].low, p.pages[j].high-p.pages[j].low
+		if wi != wj {
+			return wi < wj
+		}
+		return p.pages[i].low < p.pages[j].low
+	})
+
+	return p, nil
+}
+
+//find returns the page for status or nil if there is not
+func (p *errorPages) find(status int) *errorPage {
+	for i := range p.pages {
+		if status >= p.pages[i].low && status <= p.pages[i].high {
+			return &p.pages[i]
+		}
+	}
+
+	return nil
+}
+
+/*
+* handler stores the error pages into the context, so they are used by abortWithError. The pages which
+* can not be sent are logged by sysadmLogger if it is not nil
+ */
+func (p *errorPages) handler(sysadmLogger *logger.SysadmLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(errorPagesKey, p)
+		if sysadmLogger != nil {
+			c.Set(errorLoggerKey, sysadmLogger)
+		}
+		c.Next()
+	}
+}
+
+/*
+* recovery returns a middleware which recovers the panics of the handlers. The panic is logged by sysadmLogger
+* and the error page of 500 is sent if nothing has been sent. http.ErrAbortHandler is passed to net/http,
+* which aborts the response silently
+ */
+func recovery(sysadmLogger *logger.SysadmLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			sysadmLogger.LoggingLogf("error", "error", "Panic while serving %s %s:%v\n%s", c.Request.Method, c.Request.URL.Path, err, debug.Stack())
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			abortWithError(c, http.StatusInternalServerError)
+		}()
+		c.Next()
+	}
+}
+
+//render returns the content and type of the page for the request in c
+func (ep *errorPage) render(c *gin.Context, status int) ([]byte, string, error) {
+	if ep.tmpl == nil {
+		data, err := ioutil.ReadFile(ep.file)
+		if err != nil {
+			return nil, "", err
+		}
+		ctype := mime.TypeByExtension(filepath.Ext(ep.file))
+		if ctype == "" {
+			ctype = http.DetectContentType(data)
+		}
+		return data, ctype, nil
+	}
+
+	var buf bytes.Buffer
+	err := ep.tmpl.Execute(&buf, errorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Method:     c.Request.Method,
+		Path:       c.Request.URL.Path,
+	})
+
+	return buf.Bytes(), gin.MIMEHTML + "; charset=utf-8", err
+}
+
+/*
+* abortWithError aborts the request with status. A JSON body is sent if the client accepts JSON,
+* otherwise the error page of status is sent. The status text is sent if there is not an error page
+ */
+func abortWithError(c *gin.Context, status int) {
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.AbortWithStatusJSON(status, gin.H{
+			"status": status,
+			"error":  http.StatusText(status),
+			"path":   c.Request.URL.Path,
+		})
+		return
+	}
+
+	if v, found := c.Get(errorPagesKey); found {
+		if ep := v.(*errorPages).find(status); ep != nil {
+			data, ctype, err := ep.render(c, status)
+			if err == nil {
+				c.Data(status, ctype, data)
+				c.Abort()
+				return
+			}
+			if l, found := c.Get(errorLoggerKey); found {
+				l.(*logger.SysadmLogger).LoggingLogf("error", "error", "Send the error page %s of %d error:%s", ep.file, status, err)
+			}
+		}
+	}
+
+	c.String(status, "%d %s\n", status, http.StatusText(status))
+	c.Abort()
+}
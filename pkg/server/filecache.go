@@ -0,0 +1,326 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified May 02 2021
+**/
+
+package main
+
+import (
+	"container/list"
+	"context"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/wangyysde/bzhyserver/pkg/config"
+)
+
+//Struct for a file in the cache
+type cacheEntry struct {
+	name    string
+	modTime time.Time
+	size    int64
+	etag    string
+	ctype   string
+	data    []byte
+
+	//the compressed variants of data keyed by fileCache.variant. The coding which can not be got is stored as nil
+	variants map[string][]byte
+	cost     int64 //the bytes of data and variants
+}
+
+//Struct for the statistics of the cache in admin API
+type cacheStats struct {
+	Enabled bool   `json:"enabled"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+	MaxSize int64  `json:"maxSize"`
+}
+
+/*
+* fileCache is a LRU cache of the static files in memory. It is bounded by the total bytes of the files
+* and their variants. The entries are invalidated by checking the modification times of the files on
+* every hit or by watching the files
+ */
+type fileCache struct {
+	settings config.FileCache
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List //the front is the most recently used
+	used    int64
+
+	hits   uint64
+	misses uint64
+
+	watcher *fsnotify.Watcher //nil if invalidation is not watch
+	watched map[string]bool   //the watched directories
+}
+
+func newFileCache(settings config.FileCache) (*fileCache, error) {
+	fc := &fileCache{
+		settings: settings,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		watched:  make(map[string]bool),
+	}
+
+	if settings.Invalidation == config.InvalidateWatch {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			return nil, err
+		}
+		fc.watcher = w
+	}
+
+	return fc, nil
+}
+
+/*
+* get returns the entry of the file name. The file is read into the cache if it is not cached and not
+* larger than maxfilesize. nil is returned if the file can not be cached
+ */
+func (fc *fileCache) get(name string) *cacheEntry {
+	fc.mu.Lock()
+	elem, found := fc.entries[name]
+	fc.mu.Unlock()
+
+	if found {
+		e := elem.Value.(*cacheEntry)
+		if fc.watcher == nil {
+			info, err := os.Stat(name)
+			if err != nil || !info.ModTime().Equal(e.modTime) || info.Size() != e.size {
+				fc.remove(name)
+				return fc.load(name)
+			}
+		}
+
+		fc.mu.Lock()
+		fc.lru.MoveToFront(elem)
+		fc.mu.Unlock()
+		atomic.AddUint64(&fc.hits, 1)
+		return e
+	}
+
+	return fc.load(name)
+}
+
+//load reads the file name into the cache
+func (fc *fileCache) load(name string) *cacheEntry {
+	atomic.AddUint64(&fc.misses, 1)
+
+	info, err := os.Stat(name)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > fc.settings.MaxFileSize {
+		return nil
+	}
+
+	//watch before reading, so the changes during reading are not missed
+	if fc.watcher != nil {
+		if err = fc.watch(filepath.Dir(name)); err != nil {
+			return nil
+		}
+	}
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil || int64(len(data)) != info.Size() {
+		return nil
+	}
+
+	e := &cacheEntry{
+		name:     name,
+		modTime:  info.ModTime(),
+		size:     info.Size(),
+		etag:     fileETag(info),
+		ctype:    mime.TypeByExtension(filepath.Ext(name)),
+		data:     data,
+		variants: make(map[string][]byte),
+		cost:     info.Size(),
+	}
+	if e.ctype == "" {
+		e.ctype = http.DetectContentType(data)
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if elem, found := fc.entries[name]; found {
+		//loaded by another request
+		return elem.Value.(*cacheEntry)
+	}
+	fc.entries[name] = fc.lru.PushFront(e)
+	fc.used += e.cost
+	fc.evict()
+
+	return e
+}
+
+/*
+* variant returns the variant of e compressed in encoding. build is called to get it if it has not been got.
+* nil is returned if it can not be got. key is encoding followed by the ETag of the precompressed sibling
+* when it is used, so the variant is got again after the sibling is changed. The variants of encoding
+* with other keys are removed
+ */
+func (fc *fileCache) variant(e *cacheEntry, encoding string, key string, build func() []byte) []byte {
+	fc.mu.Lock()
+	data, found := e.variants[key]
+	fc.mu.Unlock()
+	if found {
+		return data
+	}
+
+	data = build()
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if _, found = e.variants[key]; found {
+		return data
+	}
+
+	_, cached := fc.entries[e.name]
+	for k, v := range e.variants {
+		if k == encoding || strings.HasPrefix(k, encoding+"@") {
+			delete(e.variants, k)
+			e.cost -= int64(len(v))
+			if cached {
+				fc.used -= int64(len(v))
+			}
+		}
+	}
+	e.variants[key] = data
+	e.cost += int64(len(data))
+	if cached {
+		fc.used += int64(len(data))
+		fc.evict()
+	}
+
+	return data
+}
+
+//evict removes the least recently used entries until the total bytes are not larger than maxsize. fc.mu should be locked
+func (fc *fileCache) evict() {
+	for fc.used > fc.settings.MaxSize {
+		elem := fc.lru.Back()
+		if elem == nil {
+			return
+		}
+		e := elem.Value.(*cacheEntry)
+		fc.lru.Remove(elem)
+		delete(fc.entries, e.name)
+		fc.used -= e.cost
+	}
+}
+
+//remove removes the entry of the file name
+func (fc *fileCache) remove(name string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if elem, found := fc.entries[name]; found {
+		fc.lru.Remove(elem)
+		delete(fc.entries, name)
+		fc.used -= elem.Value.(*cacheEntry).cost
+	}
+}
+
+//watch adds dir to the watcher if it has not been watched
+func (fc *fileCache) watch(dir string) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if fc.watched[dir] {
+		return nil
+	}
+	if err := fc.watcher.Add(dir); err != nil {
+		return err
+	}
+	fc.watched[dir] = true
+
+	return nil
+}
+
+/*
+* run removes the entries of the changed files until ctx is done. The entry of a file is removed too
+* when its precompressed sibling is changed. It returns immediately if invalidation is not watch
+ */
+func (fc *fileCache) run(ctx context.Context) {
+	if fc.watcher == nil {
+		return
+	}
+	defer fc.watcher.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-fc.watcher.Events:
+			if !ok {
+				return
+			}
+			fc.remove(event.Name)
+			for _, p := range precompressedExts {
+				if strings.HasSuffix(event.Name, p.ext) {
+					fc.remove(strings.TrimSuffix(event.Name, p.ext))
+				}
+			}
+			if event.Op&fsnotify.Remove != 0 {
+				fc.mu.Lock()
+				delete(fc.watched, event.Name)
+				fc.mu.Unlock()
+			}
+		case _, ok := <-fc.watcher.Errors:
+			if !ok {
+				return
+			}
+			//some events may have been lost
+			fc.purge()
+		}
+	}
+}
+
+//purge removes all of the entries
+func (fc *fileCache) purge() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.entries = make(map[string]*list.Element)
+	fc.lru.Init()
+	fc.used = 0
+}
+
+//stats returns the statistics of the cache
+func (fc *fileCache) stats() cacheStats {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return cacheStats{
+		Enabled: true,
+		Hits:    atomic.LoadUint64(&fc.hits),
+		Misses:  atomic.LoadUint64(&fc.misses),
+		Entries: len(fc.entries),
+		Bytes:   fc.used,
+		MaxSize: fc.settings.MaxSize,
+	}
+}
@@ -0,0 +1,251 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 24 2021
+**/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/netutil"
+
+	"github.com/wangyysde/bzhyserver/pkg/config"
+)
+
+//Struct for a listener which the server is serving on
+type serverListener struct {
+	cfg      config.Listener
+	listener net.Listener
+	srv      *http.Server
+	certs    *certStore //nil if TLS is not enabled
+}
+
+/*
+* bindListeners binds all of the listeners configured in server block.
+* the listeners passed by the old process when upgrading or by systemd socket activation are used instead
+* of binding them again. They are mapped to the listeners in config by name.
+* the stale socket file of a unix domain socket listener is removed before binding,
+* and the mode and owner of the socket file are set after binding
+ */
+func (svr *Server) bindListeners() error {
+	inherited, err := inheritedListeners()
+	defer func() {
+		//close the inherited listeners which are not configured any more
+		for _, l := range inherited {
+			l.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	activated, err := activatedListeners()
+	for name, l := range activated {
+		if _, found := inherited[name]; found {
+			l.Close()
+			continue
+		}
+		inherited[name] = l
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, cfg := range svr.settings.Server.Listeners {
+		if l, found := inherited[cfg.Name]; found {
+			delete(inherited, cfg.Name)
+			svr.listeners = append(svr.listeners, &serverListener{cfg: cfg, listener: l})
+			svr.logger.LoggingLogf("error", "info", "Listener %s has been inherited", cfg.Name)
+			continue
+		}
+
+		l, err := bindListener(cfg)
+		if err != nil {
+			svr.closeListeners()
+			return err
+		}
+		svr.listeners = append(svr.listeners, &serverListener{cfg: cfg, listener: l})
+	}
+
+	return nil
+}
+
+//bindListener binds the listener specified by cfg
+func bindListener(cfg config.Listener) (net.Listener, error) {
+	network, address := cfg.Network()
+	if cfg.IsUnix() {
+		if info, err := os.Lstat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err = os.Remove(address); err != nil {
+				return nil, fmt.Errorf("remove stale socket %s error: %s", address, err)
+			}
+		}
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cfg.IsUnix() {
+		return l, nil
+	}
+
+	if cfg.Mode != "" {
+		mode, err := config.ParseMode(cfg.Mode)
+		if err == nil {
+			err = os.Chmod(address, mode)
+		}
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("change mode of %s error: %s", address, err)
+		}
+	}
+
+	if cfg.Owner != "" {
+		uid, gid, _, err := lookupIDs(config.SplitOwner(cfg.Owner))
+		if err == nil {
+			err = os.Chown(address, uid, gid)
+		}
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("change owner of %s error: %s", address, err)
+		}
+	}
+
+	return l, nil
+}
+
+//closeListeners closes the listeners which have been bound
+func (svr *Server) closeListeners() {
+	for _, sl := range svr.listeners {
+		sl.listener.Close()
+	}
+	svr.listeners = nil
+}
+
+/*
+* newHTTPServer creates a http.Server for the listener with the options of it.
+* all of the listeners share the gin engines of the server except the redirecting listeners.
+* the certificates are loaded here, so it should be called before dropping privileges
+ */
+func (svr *Server) newHTTPServer(sl *serverListener) (*http.Server, error) {
+	settings := svr.settings.Server
+	srv := &http.Server{
+		Handler:           withResponseWriter(svr.handler()),
+		ReadTimeout:       settings.ReadTimeout,
+		ReadHeaderTimeout: settings.ReadHeaderTimeout,
+		WriteTimeout:      settings.WriteTimeout,
+		IdleTimeout:       settings.IdleTimeout,
+		MaxHeaderBytes:    settings.MaxHeaderBytes,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, listenerKey{}, sl)
+		},
+	}
+
+	if sl.cfg.RedirectHTTPS > 0 {
+		srv.Handler = redirectHandler(sl.cfg.RedirectHTTPS)
+	}
+
+	if !sl.cfg.HasProtocol(config.ProtoHTTP2) {
+		//HTTP/2 is enabled by http.Server automatically when TLS is enabled unless TLSNextProto is not nil
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+
+	h2Server := svr.newHTTP2Server()
+	if sl.cfg.H2C {
+		srv.Handler = h2c.NewHandler(srv.Handler, h2Server)
+	}
+
+	if sl.cfg.TLSEnabled() {
+		store, err := newCertStore(append(sl.cfg.Certificates(), svr.vhostCertificates()...), sl.cfg.TLS.ClientCA, sl.cfg.TLS.CRL)
+		if err != nil {
+			return nil, err
+		}
+		if srv.TLSConfig, err = newTLSConfig(sl.cfg, store); err != nil {
+			return nil, err
+		}
+		sl.certs = store
+
+		if sl.cfg.HasProtocol(config.ProtoHTTP2) {
+			if err = http2.ConfigureServer(srv, h2Server); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return srv, nil
+}
+
+//newHTTP2Server creates a http2.Server with the HTTP/2 settings in server block
+func (svr *Server) newHTTP2Server() *http2.Server {
+	h2 := svr.settings.Server.HTTP2
+	return &http2.Server{
+		MaxConcurrentStreams: h2.MaxConcurrentStreams,
+		MaxReadFrameSize:     h2.MaxReadFrameSize,
+		IdleTimeout:          h2.IdleTimeout,
+	}
+}
+
+//prepareServers creates the http.Server for every listener
+func (svr *Server) prepareServers() error {
+	for _, sl := range svr.listeners {
+		srv, err := svr.newHTTPServer(sl)
+		if err != nil {
+			return fmt.Errorf("listener %s: %s", sl.cfg.Name, err)
+		}
+		sl.srv = srv
+	}
+
+	return nil
+}
+
+/*
+* serveListeners starts serving on all of the listeners in background.
+* the listener is wrapped by a limiting listener if the number of connections is limited
+ */
+func (svr *Server) serveListeners() {
+	for _, sl := range svr.listeners {
+		go func(sl *serverListener) {
+			l := sl.listener
+			if sl.cfg.MaxConns > 0 {
+				l = netutil.LimitListener(l, sl.cfg.MaxConns)
+			}
+
+			var err error
+			if sl.certs != nil {
+				err = sl.srv.ServeTLS(l, "", "")
+			} else {
+				err = sl.srv.Serve(l)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				svr.logger.LoggingLogf("error", "fatal", "Serve on %s error:%s", sl.cfg.Address, err)
+			}
+		}(sl)
+		if sl.certs != nil {
+			go svr.watchCerts(sl)
+		}
+		svr.logger.LoggingLogf("error", "info", "Server is listening on %s", sl.cfg.Address)
+	}
+}
@@ -0,0 +1,282 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified May 04 2021
+**/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/wangyysde/bzhyserver/pkg/config"
+	"github.com/wangyysde/bzhyserver/pkg/logger"
+)
+
+//The key of the gin context in the context of the requests passed to the upstream servers
+type ginContextKey struct{}
+
+//Struct for a location block
+type location struct {
+	cfg     config.Location
+	re      *regexp.Regexp //nil if it is not a regex location
+	handler gin.HandlerFunc
+}
+
+/*
+* locations selects the location for the requests. The exact location is selected first, then the first
+* matching regex location in config order, and then the longest matching prefix location.
+* the requests matching no location are served by fallback
+ */
+type locations struct {
+	exact    map[string]*location
+	regex    []*location
+	prefix   []*location //sorted by the length of path in descending order
+	fallback gin.HandlerFunc
+}
+
+/*
+* newLocations creates the locations of the virtual host vh. They inherit autoindex, compression and
+* Cache-Control rules of the server if they are not set, the cache of static files, and root and index of vh
+ */
+func (svr *Server) newLocations(vh *vhost, fallback gin.HandlerFunc) (*locations, error) {
+	settings := svr.settings.Server
+	ls := &locations{exact: make(map[string]*location), fallback: fallback}
+
+	for _, cfg := range vh.cfg.Locations {
+		l := &location{cfg: cfg}
+		rules := cfg.CacheControl
+		if len(rules) == 0 {
+			rules = settings.CacheControl
+		}
+
+		switch cfg.Handler {
+		case config.HandlerProxy:
+			handler, err := proxyHandler(cfg, rules, vh.logger)
+			if err != nil {
+				return nil, fmt.Errorf("location %s: %s", cfg.Path, err)
+			}
+			l.handler = handler
+		case config.HandlerRedirect:
+			l.handler = l.redirect
+		case config.HandlerReturn:
+			l.handler = l.respond
+		default:
+			l.handler = svr.locationStatic(vh, cfg, rules).handle
+		}
+
+		switch cfg.Match {
+		case config.MatchExact:
+			ls.exact[cfg.Path] = l
+		case config.MatchRegex:
+			re, err := regexp.Compile(cfg.Path)
+			if err != nil {
+				return nil, fmt.Errorf("location %s: %s", cfg.Path, err)
+			}
+			l.re = re
+			ls.regex = append(ls.regex, l)
+		default:
+			ls.prefix = append(ls.prefix, l)
+		}
+	}
+
+	sort.SliceStable(ls.prefix, func(i, j int) bool {
+		return len(ls.prefix[i].cfg.Path) > len(ls.prefix[j].cfg.Path)
+	})
+
+	return ls, nil
+}
+
+//locationStatic creates the static handler of the location cfg of the virtual host vh
+func (svr *Server) locationStatic(vh *vhost, cfg config.Location, rules []config.CacheRule) *staticHandler {
+	settings := svr.settings.Server
+	root, indexes := vh.cfg.RootPath, vh.cfg.Index
+	if cfg.Root != "" {
+		root = cfg.Root
+	}
+	if len(cfg.Index) > 0 {
+		indexes = cfg.Index
+	}
+
+	autoindex := settings.Autoindex
+	if cfg.Autoindex != nil {
+		autoindex = *cfg.Autoindex
+	}
+
+	compression := &settings.Compression
+	if cfg.Compression != nil {
+		compression = cfg.Compression
+	}
+
+	h := newStaticHandler(root, indexes, autoindex, compression, svr.cache, rules)
+	if cfg.Alias != "" {
+		h.root = cfg.Alias
+		h.prefix = cfg.Path
+	}
+
+	return h
+}
+
+//match returns the location for urlPath or nil if there is not
+func (ls *locations) match(urlPath string) *location {
+	if l, found := ls.exact[urlPath]; found {
+		return l
+	}
+
+	for _, l := range ls.regex {
+		if l.re.MatchString(urlPath) {
+			return l
+		}
+	}
+
+	for _, l := range ls.prefix {
+		if strings.HasPrefix(urlPath, l.cfg.Path) {
+			return l
+		}
+	}
+
+	return nil
+}
+
+//handle serves the request by the location matching the path of it
+func (ls *locations) handle(c *gin.Context) {
+	l := ls.match(c.Request.URL.Path)
+	if l == nil {
+		ls.fallback(c)
+		return
+	}
+
+	if l.cfg.Handler != config.HandlerProxy {
+		for name, value := range l.cfg.Headers {
+			c.Header(name, value)
+		}
+	}
+
+	if !l.authorize(c) {
+		return
+	}
+
+	if l.cfg.Compression != nil {
+		useCompression(c, l.cfg.Compression)
+	}
+
+	l.handler(c)
+}
+
+//dummyHash is compared with the passwords of the unknown users of HTTP basic authentication
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("sysadm"), bcrypt.DefaultCost)
+
+/*
+* authorize checks the client certificate and the credentials of HTTP basic authentication of the request.
+* the request is aborted and false is returned if any check fails
+ */
+func (l *location) authorize(c *gin.Context) bool {
+	auth := l.cfg.Auth
+	if auth.ClientCert && getClientIdentity(c) == nil {
+		abortWithError(c, http.StatusForbidden)
+		return false
+	}
+
+	if len(auth.Users) > 0 {
+		user, password, ok := c.Request.BasicAuth()
+		hash, found := auth.Users[user]
+		if !found {
+			//compare with a hash anyway, so the time of the response does not tell whether the user exists
+			hash = string(dummyHash)
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil || !ok || !found {
+			c.Header("WWW-Authenticate", "Basic realm="+strconv.Quote(auth.Realm))
+			abortWithError(c, http.StatusUnauthorized)
+			return false
+		}
+		c.Set(gin.AuthUserKey, user)
+	}
+
+	return true
+}
+
+//redirect redirects the request to the target of the location. The captures of a regex location are expanded
+func (l *location) redirect(c *gin.Context) {
+	target := l.cfg.Redirect
+	if l.re != nil {
+		if m := l.re.FindStringSubmatchIndex(c.Request.URL.Path); m != nil {
+			target = string(l.re.ExpandString(nil, target, c.Request.URL.Path, m))
+		}
+	}
+
+	c.Redirect(l.cfg.Status, target)
+	c.Abort()
+}
+
+//respond returns the status and body of the location
+func (l *location) respond(c *gin.Context) {
+	c.String(l.cfg.Status, "%s", l.cfg.Body)
+	c.Abort()
+}
+
+/*
+* proxyHandler creates the handler proxying the requests to the upstream server of the location cfg.
+* the headers of the location and the Cache-Control rules are applied to the responses of the upstream server
+ */
+func proxyHandler(cfg config.Location, rules []config.CacheRule, sysadmLogger *logger.SysadmLogger) (gin.HandlerFunc, error) {
+	target, err := url.Parse(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Header.Set("X-Forwarded-Host", r.Host)
+		if r.TLS != nil {
+			r.Header.Set("X-Forwarded-Proto", "https")
+		} else {
+			r.Header.Set("X-Forwarded-Proto", "http")
+		}
+	}
+	proxy.ModifyResponse = func(res *http.Response) error {
+		c := res.Request.Context().Value(ginContextKey{}).(*gin.Context)
+		for name, value := range cfg.Headers {
+			res.Header.Set(name, value)
+		}
+		if res.StatusCode < http.StatusBadRequest {
+			applyCacheRules(res.Header, rules, c.Request.URL.Path)
+		}
+		return nil
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		sysadmLogger.LoggingLogf("error", "error", "Proxy %s to %s error:%s", r.URL.Path, cfg.Proxy, err)
+		abortWithError(r.Context().Value(ginContextKey{}).(*gin.Context), http.StatusBadGateway)
+	}
+
+	return func(c *gin.Context) {
+		r := c.Request.WithContext(context.WithValue(c.Request.Context(), ginContextKey{}, c))
+		proxy.ServeHTTP(c.Writer, r)
+		c.Abort()
+	}, nil
+}
@@ -0,0 +1,227 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified May 05 2021
+**/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wangyysde/bzhyserver/pkg/config"
+	"github.com/wangyysde/bzhyserver/pkg/logger"
+)
+
+//The maximum times of running the rules for a request. It breaks the loops of last rules
+const maxRewritePasses = 10
+
+//The key of the mark of the rewritten requests in the context of the requests
+type rewrittenKey struct{}
+
+//Struct for a condition of a rewrite rule
+type rewriteCondition struct {
+	cfg config.RewriteCondition
+	re  *regexp.Regexp
+}
+
+//Struct for a rewrite rule
+type rewriteRule struct {
+	cfg   config.RewriteRule
+	re    *regexp.Regexp
+	conds []rewriteCondition
+}
+
+//rewriter rewrites or redirects the requests by the rules before routing
+type rewriter struct {
+	rules  []rewriteRule
+	debug  bool
+	logger *logger.SysadmLogger
+}
+
+func newRewriter(settings config.Rewrite, sysadmLogger *logger.SysadmLogger) (*rewriter, error) {
+	rw := &rewriter{debug: settings.Debug, logger: sysadmLogger}
+	for _, cfg := range settings.Rules {
+		re, err := regexp.Compile(cfg.Match)
+		if err != nil {
+			return nil, err
+		}
+
+		rule := rewriteRule{cfg: cfg, re: re}
+		for _, c := range cfg.Conditions {
+			cre, err := regexp.Compile(c.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			rule.conds = append(rule.conds, rewriteCondition{cfg: c, re: cre})
+		}
+		rw.rules = append(rw.rules, rule)
+	}
+
+	return rw, nil
+}
+
+//pass returns true if the condition passes for the request r which query string is query
+func (c *rewriteCondition) pass(r *http.Request, query string) bool {
+	var value string
+	switch c.cfg.Type {
+	case config.ConditionHost:
+		value = r.Host
+		if h, _, err := net.SplitHostPort(value); err == nil {
+			value = h
+		}
+	case config.ConditionHeader:
+		value = r.Header.Get(c.cfg.Name)
+	case config.ConditionMethod:
+		value = r.Method
+	case config.ConditionQuery:
+		value = query
+		if c.cfg.Name != "" {
+			values, _ := url.ParseQuery(query)
+			value = values.Get(c.cfg.Name)
+		}
+	}
+
+	return c.re.MatchString(value) != c.cfg.Negate
+}
+
+/*
+* splitReplacement splits the expanded replacement into the path and query. The query in replacement is
+* prepended to the query of the request, and the query of the request is dropped if replacement ends with ?
+ */
+func splitReplacement(replaced string, query string) (string, string) {
+	i := strings.Index(replaced, "?")
+	if i < 0 {
+		return replaced, query
+	}
+
+	newPath, newQuery := replaced[:i], replaced[i+1:]
+	if i == len(replaced)-1 {
+		return newPath, ""
+	}
+	if query != "" {
+		newQuery += "&" + query
+	}
+
+	return newPath, newQuery
+}
+
+/*
+* apply runs the rules for the request r. It returns the target and status code if the request should be
+* redirected, or the new path and query if it has been rewritten. changed is false if no rule is applied
+ */
+func (rw *rewriter) apply(r *http.Request) (target string, status int, newPath string, newQuery string, changed bool, err error) {
+	newPath, newQuery = r.URL.Path, r.URL.RawQuery
+
+	for n := 0; n < maxRewritePasses; n++ {
+		restart := false
+		for i := range rw.rules {
+			rule := &rw.rules[i]
+			m := rule.re.FindStringSubmatchIndex(newPath)
+			if m == nil || !rule.pass(r, newQuery) {
+				continue
+			}
+
+			p, q := splitReplacement(string(rule.re.ExpandString(nil, rule.cfg.Replacement, newPath, m)), newQuery)
+			if rw.debug {
+				rw.logger.LoggingLogf("error", "info", "Rewrite %s to %s by rule %d (%s) with flag %s", newPath, p, i, rule.cfg.Match, rule.cfg.Flag)
+			}
+
+			if rule.cfg.IsRedirect() {
+				target = p
+				if q != "" {
+					target += "?" + q
+				}
+				return target, rule.cfg.RedirectStatus(), newPath, newQuery, changed, nil
+			}
+
+			if !strings.HasPrefix(p, "/") {
+				p = "/" + p
+			}
+			newPath, newQuery, changed = p, q, true
+			if rule.cfg.Flag == config.RewriteBreak {
+				return "", 0, newPath, newQuery, changed, nil
+			}
+			if rule.cfg.Flag == config.RewriteLast {
+				restart = true
+				break
+			}
+		}
+
+		if !restart {
+			return "", 0, newPath, newQuery, changed, nil
+		}
+	}
+
+	return "", 0, newPath, newQuery, changed, fmt.Errorf("the rules have been run %d times for %s", maxRewritePasses, r.URL.Path)
+}
+
+//pass returns true if all of the conditions of the rule pass
+func (rule *rewriteRule) pass(r *http.Request, query string) bool {
+	for i := range rule.conds {
+		if !rule.conds[i].pass(r, query) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+* handler returns the middleware running the rules. The rewritten request is routed again by engine,
+* and the middlewares before it are run again, so they should not write the response
+ */
+func (rw *rewriter) handler(engine *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(rw.rules) == 0 || isRewritten(c.Request) {
+			c.Next()
+			return
+		}
+
+		target, status, newPath, newQuery, changed, err := rw.apply(c.Request)
+		switch {
+		case err != nil:
+			rw.logger.LoggingLogf("error", "error", "Rewrite %s error:%s", c.Request.URL.Path, err)
+			abortWithError(c, http.StatusInternalServerError)
+		case status != 0:
+			c.Redirect(status, target)
+			c.Abort()
+		case changed:
+			r := c.Request.WithContext(context.WithValue(c.Request.Context(), rewrittenKey{}, true))
+			u := *r.URL
+			u.Path, u.RawPath, u.RawQuery = newPath, "", newQuery
+			r.URL = &u
+			c.Request = r
+			engine.HandleContext(c)
+			c.Abort()
+		default:
+			c.Next()
+		}
+	}
+}
+
+//isRewritten returns true if r is the request rewritten and being routed again
+func isRewritten(r *http.Request) bool {
+	return r.Context().Value(rewrittenKey{}) != nil
+}
@@ -22,11 +22,12 @@ package main
 import (
 	"context"
 	"fmt"
-	"net/http"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -43,6 +44,7 @@ type Server struct {
 
 	shutdownReason     string
 	shutdownInProgress bool
+	upgraded           bool //the new process started by upgrading is serving on the listeners
 
 	rootPath string
 
@@ -51,19 +53,87 @@ type Server struct {
 	index   string
 	pidFile string
 
-	r *gin.Engine
+	r      *gin.Engine  //the engine of the site of server block
+	sites  []*vhost     //the site of server block and the virtual hosts
+	vhosts *vhostRouter //nil if there is not any virtual host
+	cache  *fileCache   //nil if the cache of static files is disabled
+
+	settings *config.Configs
+	logger   *logger.SysadmLogger
+
+	listeners []*serverListener
 }
 
 var (
-	a          = kingpin.New(filepath.Base(os.Args[0]), "A command-line "+config.DefaultAppSettings.Progname+" application.")
-	configFile = a.Flag("config", "Configuration file path").Default(config.DefaultAppSettings.ConFile).String()
-	version    = a.Flag("version", "Show the version information for "+config.DefaultAppSettings.Progname).Bool()
+	a            = kingpin.New(filepath.Base(os.Args[0]), "A command-line "+config.DefaultAppSettings.Progname+" application.")
+	configFile   = a.Flag("config", "Configuration file path").Default(config.DefaultAppSettings.ConFile).String()
+	configFormat = a.Flag("config-format", "Format of configuration file. Detected from the file extension if not set").Enum(config.Formats()...)
+	version      = a.Flag("version", "Show the version information for "+config.DefaultAppSettings.Progname).Bool()
+	daemon       = a.Flag("daemon", "Run the server in background detached from the terminal").Bool()
+
+	serveCmd        = a.Command("serve", "Start the server. It is the default command").Default()
+	configCmd       = a.Command("config", "Manage the configuration file")
+	configCheckCmd  = configCmd.Command("check", "Check the configuration file without starting the server")
+	configDumpCmd   = configCmd.Command("dump", "Print the effective configuration with defaults and overrides applied")
+	dumpFormat      = configDumpCmd.Flag("format", "Output format: yaml or json").Default("yaml").Enum("yaml", "json")
+	dumpSources     = configDumpCmd.Flag("show-sources", "Print where the value of every field comes from instead of the configuration").Bool()
+	configSchemaCmd = configCmd.Command("schema", "Print the JSON Schema of the configuration file")
+	versionCmd      = a.Command("version", "Show the version information for "+config.DefaultAppSettings.Progname)
+	versionFormat   = versionCmd.Flag("format", "Output format: text or json").Default("text").Enum("text", "json")
 )
 
 var Svr = new(Server)
 
+//The max time for waiting the running requests to finish when shutting down
+const shutdownTimeout = 30 * time.Second
+
+//values of the command line flags which override the settings in config file. key is the path of a field
+var flagOverrides = make(map[string]string)
+
+//Struct for a command line flag which overrides a field of config
+type overrideFlag struct {
+	path string
+}
+
+func (f *overrideFlag) Set(value string) error {
+	flagOverrides[f.path] = value
+	return nil
+}
+
+func (f *overrideFlag) String() string {
+	return flagOverrides[f.path]
+}
+
+//registerOverrideFlags adds a flag for every field of config, such as --server.port
+func registerOverrideFlags() {
+	for _, o := range config.Overrides() {
+		a.Flag(o.Flag, "Override "+o.Path+" in config file. Env: "+o.Env).PlaceHolder("VALUE").SetValue(&overrideFlag{path: o.Path})
+	}
+}
+
+/*
+* init_serer builds the gin engines, binds the listen sockets, drops the privileges to the configured
+* user and group, and then starts serving in background.
+* Error no: AABBB. AA: file seq,main is 1; BBB: error no
+ */
 func init_serer() (ret int) {
-	r := gin.Default()
+	settings := Svr.settings
+	pages, err := newErrorPages(settings.Server.RootPath, settings.Server.ErrorPages)
+	if err != nil {
+		Svr.logger.LoggingLogf("error", "fatal", "Load error pages error:%s", err)
+		return 10017
+	}
+
+	if settings.Server.Cache.Enable {
+		cache, err := newFileCache(settings.Server.Cache)
+		if err != nil {
+			Svr.logger.LoggingLogf("error", "fatal", "Create the cache of static files error:%s", err)
+			return 10016
+		}
+		Svr.cache = cache
+		go cache.run(Svr.icontext)
+	}
+
 	//	r.SetAccLogHandler(WriteLog2Acclog)
 	//	r.SetErrLogHandler(WriteLog2Errlog)
 
@@ -82,28 +152,224 @@ func init_serer() (ret int) {
 
 	*/
 
-	r.GET("/", func(c *gin.Context) {
-		time.Sleep(5 * time.Second)
-		c.String(http.StatusOK, "Welcome Gin Server")
-		c.JSON(200, gin.H{
-			"Blog":   "www.flysnow.org",
-			"wechat": "flysnow_org",
-		})
-	})
+	if ret = Svr.initSites(pages); ret > 0 {
+		return ret
+	}
+
+	if err := Svr.bindListeners(); err != nil {
+		Svr.logger.LoggingLogf("error", "fatal", "Bind listeners error:%s", err)
+		return 10011
+	}
+
+	if err := Svr.prepareServers(); err != nil {
+		Svr.logger.LoggingLogf("error", "fatal", "Prepare servers error:%s", err)
+		return 10015
+	}
+
+	uid, gid, groups, err := lookupIDs(settings.Server.User, settings.Server.Group)
+	if err != nil {
+		Svr.logger.LoggingLogf("error", "fatal", "Lookup user %s and group %s error:%s", settings.Server.User, settings.Server.Group, err)
+		return 10012
+	}
+	//the ids are not changed if the process has been running as them, such as the new process of upgrading
+	if uid == os.Geteuid() {
+		uid = -1
+	}
+	if gid == os.Getegid() {
+		gid = -1
+	}
+	if uid < 0 && gid < 0 {
+		groups = nil
+	}
 
-	srv := &http.Server{
-		Addr:    ":" + strconv.Itoa(8081),
-		Handler: r,
+	files := append([]string{Svr.pidFile, settings.Logger.AccessLog, settings.Logger.ErrorLog}, Svr.vhostLogFiles()...)
+	if err = chownFiles(uid, gid, files...); err != nil {
+		Svr.logger.LoggingLogf("error", "fatal", "Change the owner of log and pid files error:%s", err)
+		return 10013
 	}
 
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			//WriteStartLog(fmt.Sprintf("Listen %s:%s %s", config.Cfg.Host, config.Cfg.Port, err), "fatal")
+	if err = dropPrivileges(uid, gid, groups); err != nil {
+		Svr.logger.LoggingLogf("error", "fatal", "Drop privileges error:%s", err)
+		return 10014
+	}
+	if uid >= 0 || gid >= 0 {
+		Svr.checkCertsReadable()
+	}
+
+	Svr.serveListeners()
+
+	return 0
+
+}
+
+/*
+* accessLogger returns a middleware which logs every request to the access log.
+* the subject and SANs of the verified client certificate are logged as the last field, or "-" if there is not
+ */
+func accessLogger(sysadmLogger *logger.SysadmLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isRewritten(c.Request) {
+			//it has been logged when routing the request first
+			c.Next()
+			return
 		}
-	}()
+
+		start := time.Now()
+		c.Next()
+		subject := "-"
+		if id := getClientIdentity(c); id != nil {
+			subject = id.String()
+		}
+		size := int64(c.Writer.Size())
+		if sent, found := c.Get(sentBytesKey); found {
+			//the bytes sent by sendfile are not counted by gin
+			size += sent.(int64)
+		}
+		sysadmLogger.LoggingLogf("access", "info", "%s \"%s %s %s\" %d %d %s \"%s\" \"%s\"",
+			c.ClientIP(), c.Request.Method, c.Request.RequestURI, c.Request.Proto,
+			c.Writer.Status(), size, time.Since(start), c.Request.UserAgent(), subject)
+	}
+}
+
+//openLogs opens the access log and error log files configured in settings
+func (svr *Server) openLogs() (ret int) {
+	svr.logger.LoggerFormat = svr.settings.Logger.Logtype
+	if _, err := svr.logger.OpenLogfile("access", svr.settings.Logger.AccessLog); err != nil {
+		svr.logger.LoggingLog("stdout", "error", err)
+		return 10007
+	}
+
+	if _, err := svr.logger.OpenLogfile("error", svr.settings.Logger.ErrorLog); err != nil {
+		svr.logger.LoggingLog("stdout", "error", err)
+		return 10008
+	}
 
 	return 0
+}
 
+//closeLogs closes the log files opened by openLogs
+func (svr *Server) closeLogs() {
+	svr.closeVHostLogs()
+	svr.logger.EndLogger("access")
+	svr.logger.EndLogger("error")
+}
+
+/*
+* preparePidFile sets the path of the pid file. It is moved into a directory owned by server.user
+* if the server drops privileges, so the pid file can be removed when the server is shut down
+ */
+func (svr *Server) preparePidFile() (ret int) {
+	settings := svr.settings.Server
+	uid, gid, _, err := lookupIDs(settings.User, settings.Group)
+	if err != nil {
+		svr.logger.LoggingLogf("error", "fatal", "Lookup user %s and group %s error:%s", settings.User, settings.Group, err)
+		return 10012
+	}
+	mode, err := config.ParseMode(settings.DirMode)
+	if err != nil {
+		svr.logger.LoggingLogf("error", "fatal", "Parse the mode of the directory of pid file error:%s", err)
+		return 10009
+	}
+
+	if svr.pidFile, err = preparePidFile(settings.PidPath, uid, gid, mode); err != nil {
+		svr.logger.LoggingLogf("error", "fatal", "Prepare the directory of pid file %s error:%s", settings.PidPath, err)
+		return 10009
+	}
+	if svr.pidFile != settings.PidPath {
+		svr.logger.LoggingLogf("error", "info", "The pid file is %s, so it can be removed after dropping privileges", svr.pidFile)
+	}
+
+	return 0
+}
+
+//writePidFile writes the pid of the process to the pid file set by preparePidFile
+func (svr *Server) writePidFile() (ret int) {
+	err := ioutil.WriteFile(svr.pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+	if err != nil {
+		svr.logger.LoggingLogf("error", "error", "Write pid file %s error:%s", svr.pidFile, err)
+		return 10009
+	}
+
+	return 0
+}
+
+/*
+* shutdown stops accepting new connections and waits for the running requests to finish,
+* then removes the pid file
+ */
+func (svr *Server) shutdown(reason string) {
+	if svr.shutdownInProgress {
+		return
+	}
+	svr.shutdownInProgress = true
+	svr.shutdownReason = reason
+	if !svr.upgraded {
+		svr.notifySystemd(sdStopping)
+	}
+	svr.logger.LoggingLogf("error", "info", "Shutting down server: %s", reason)
+
+	if svr.shutdownFn != nil {
+		svr.shutdownFn()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, sl := range svr.listeners {
+		if sl.srv == nil {
+			sl.listener.Close()
+			continue
+		}
+		if err := sl.srv.Shutdown(ctx); err != nil {
+			svr.logger.LoggingLogf("error", "error", "Shutdown server on %s error:%s", sl.cfg.Address, err)
+		}
+	}
+
+	if svr.pidFile != "" {
+		if err := os.Remove(svr.pidFile); err != nil {
+			svr.logger.LoggingLogf("error", "warn", "Remove pid file %s error:%s", svr.pidFile, err)
+		}
+	}
+}
+
+/*
+* loadConfig reads the config file and applies the overrides from environment variables and command line flags.
+* the settings should be checked by CheckConfig after loading. errno is not zero if any step fails
+ */
+func loadConfig(sysadmLogger *logger.SysadmLogger) (settings *config.Configs, errno int) {
+	settings = config.New()
+	settings.App.ConFormat = *configFormat
+	if err := settings.ParseConfig(*configFile); err != nil {
+		sysadmLogger.LoggingLogf("stdout", "error", "Parse config file %s error:%s", *configFile, err)
+		return settings, 10002
+	}
+
+	for _, warning := range settings.Deprecations() {
+		sysadmLogger.LoggingLog("stdout", "warn", warning)
+	}
+
+	if err := settings.ApplyEnv(); err != nil {
+		logConfigErrors(sysadmLogger, err)
+		return settings, 10005
+	}
+
+	if err := settings.ApplyFlags(flagOverrides); err != nil {
+		logConfigErrors(sysadmLogger, err)
+		return settings, 10006
+	}
+
+	return settings, 0
+}
+
+//logConfigErrors logs all of the problems in err one per line if err is a *config.CheckErrors
+func logConfigErrors(sysadmLogger *logger.SysadmLogger, err error) {
+	if checkErrs, ok := err.(*config.CheckErrors); ok {
+		for _, line := range checkErrs.Lines() {
+			sysadmLogger.LoggingLog("stdout", "error", line)
+		}
+		return
+	}
+
+	sysadmLogger.LoggingLog("stdout", "error", err)
 }
 
 func main() {
@@ -113,24 +379,119 @@ func main() {
 	defer sysadmLogger.EndLogger("stdout")
 
 	a.HelpFlag.Short('h')
-	_, err := a.Parse(os.Args[1:])
+	registerOverrideFlags()
+	command, err := a.Parse(os.Args[1:])
 	if err != nil {
 		sysadmLogger.LoggingLogf("stdout", "info", "Unkown  commandline arguments:%s", err)
 		os.Exit(10001) //Error no: AABBB. AA: file seq,main is 1; BBB: error no
 	}
 
+	if *version {
+		os.Exit(runVersion(sysadmLogger, "text"))
+	}
+
+	switch command {
+	case versionCmd.FullCommand():
+		os.Exit(runVersion(sysadmLogger, *versionFormat))
+	case configCheckCmd.FullCommand():
+		os.Exit(runConfigCheck(sysadmLogger))
+	case configDumpCmd.FullCommand():
+		os.Exit(runConfigDump(sysadmLogger, *dumpFormat, *dumpSources))
+	case configSchemaCmd.FullCommand():
+		os.Exit(runConfigSchema(sysadmLogger))
+	}
+
+	settings, errno := loadConfig(sysadmLogger)
+	if errno > 0 {
+		os.Exit(errno)
+	}
+
+	if err = settings.CheckConfig(); err != nil {
+		logConfigErrors(sysadmLogger, err)
+		os.Exit(10003)
+	}
+
+	if err = settings.ApplyConfig(); err != nil {
+		logConfigErrors(sysadmLogger, err)
+		os.Exit(10004)
+	}
+
+	if *daemon && !isDaemonChild() {
+		pid, err := daemonize()
+		if err != nil {
+			sysadmLogger.LoggingLogf("stdout", "error", "Start daemon error:%s. See %s for details", err, settings.Logger.ErrorLog)
+			os.Exit(10010)
+		}
+		sysadmLogger.LoggingLogf("stdout", "info", "Server has been started in background with pid %d", pid)
+		os.Exit(0)
+	}
+	if isDaemonChild() {
+		//the config file has been loaded from the working directory of the parent, which should not be kept busy
+		if err = os.Chdir("/"); err != nil {
+			sysadmLogger.LoggingLogf("stdout", "error", "Change the working directory error:%s", err)
+		}
+	}
+
+	Svr.settings = settings
+	Svr.logger = sysadmLogger
+	Svr.rootPath = settings.Server.RootPath
+	Svr.index = settings.Server.Indexs
+	Svr.nindexs = strings.Fields(settings.Server.Indexs)
+	Svr.icontext, Svr.shutdownFn = context.WithCancel(context.Background())
+
+	if ret := Svr.openLogs(); ret > 0 {
+		os.Exit(ret)
+	}
+	defer Svr.closeLogs()
+
+	if ret := Svr.preparePidFile(); ret > 0 {
+		os.Exit(ret)
+	}
+	if ret := Svr.writePidFile(); ret > 0 {
+		os.Exit(ret)
+	}
+
 	if ret := init_serer(); ret > 0 {
 		fmt.Printf("Starting the server ERROR")
+		Svr.shutdown("starting error")
+		Svr.closeLogs()
+		os.Exit(ret)
 	}
 
-	quit := make(chan os.Signal)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	if err = notifyUpgradeReady(); err != nil {
+		Svr.logger.LoggingLogf("error", "error", "Notify the old process error:%s", err)
+	}
+	if err = notifyDaemonReady(); err != nil {
+		Svr.logger.LoggingLogf("error", "error", "Notify the parent process error:%s", err)
+	}
+	Svr.notifySystemd(fmt.Sprintf("%s\nMAINPID=%d", sdReady, os.Getpid()))
+	go Svr.watchdog()
 
+	reason := waitSignals()
 	fmt.Printf("Shutting down server...")
-	//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-
-	//defer cancel()
+	Svr.shutdown(reason)
+}
 
-	os.Exit(0)
+/*
+* waitSignals handles the signals until the server should be shut down and returns the reason.
+* SIGHUP reloads the certificates and SIGUSR2 upgrades the server
+ */
+func waitSignals() (reason string) {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
+	for {
+		sig := <-quit
+		switch sig {
+		case syscall.SIGHUP:
+			Svr.notifySystemd(sdReloading)
+			Svr.reloadCerts()
+			Svr.notifySystemd(sdReady)
+		case syscall.SIGUSR2:
+			if pid, ok := Svr.upgrade(); ok {
+				return fmt.Sprintf("upgraded to process %d", pid)
+			}
+		default:
+			return "received signal " + sig.String()
+		}
+	}
 }
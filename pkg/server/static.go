@@ -0,0 +1,360 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified May 02 2021
+**/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wangyysde/bzhyserver/pkg/config"
+)
+
+//errOutOfRoot is returned when the path of a request points to a file out of the root
+var errOutOfRoot = errors.New("the file is out of the root")
+
+//Struct for serving the static files under root
+type staticHandler struct {
+	root        string
+	indexes     []string
+	autoindex   config.Autoindex
+	compression *config.Compression
+	cache       *fileCache //nil if the cache is disabled
+	cacheRules  []config.CacheRule
+	prefix      string //the prefix of the request paths which is replaced by root. It is set for alias locations
+}
+
+func newStaticHandler(root string, indexes []string, autoindex config.Autoindex, compression *config.Compression, cache *fileCache,
+	cacheRules []config.CacheRule) *staticHandler {
+	return &staticHandler{root: root, indexes: indexes, autoindex: autoindex, compression: compression, cache: cache, cacheRules: cacheRules}
+}
+
+/*
+* resolve returns the path of the file for urlPath under the root. The symbolic links are followed,
+* and errOutOfRoot is returned if the file is out of the root
+ */
+func (h *staticHandler) resolve(urlPath string) (string, error) {
+	return h.confine(filepath.Join(h.root, filepath.FromSlash(path.Clean("/"+urlPath))))
+}
+
+//confine follows the symbolic links of name and returns errOutOfRoot if it is out of the root
+func (h *staticHandler) confine(name string) (string, error) {
+	root, err := filepath.EvalSymlinks(h.root)
+	if err != nil {
+		return "", err
+	}
+
+	if name, err = filepath.EvalSymlinks(name); err != nil {
+		return "", err
+	}
+
+	if name != root && !strings.HasPrefix(name, root+string(filepath.Separator)) {
+		return "", errOutOfRoot
+	}
+
+	return name, nil
+}
+
+//findIndex returns the path of the first index file in dir which is the path of the request. "" is returned if there is not
+func (h *staticHandler) findIndex(dir string) string {
+	for _, index := range h.indexes {
+		name, err := h.resolve(path.Join(dir, index))
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(name); err == nil && info.Mode().IsRegular() {
+			return name
+		}
+	}
+
+	return ""
+}
+
+/*
+* handle serves the file for the path of the request. For a directory, the index file in it is served
+* and the directory is listed if there is not any index file and autoindex is enabled
+ */
+func (h *staticHandler) handle(c *gin.Context) {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		c.Header("Allow", "GET, HEAD")
+		abortWithError(c, http.StatusMethodNotAllowed)
+		return
+	}
+
+	urlPath := c.Request.URL.Path
+	rel := strings.TrimPrefix(urlPath, h.prefix)
+	//the prefix of an alias location matches whole segments only, so /img does not serve /imgfoo from the alias
+	if h.prefix != "" && !strings.HasSuffix(h.prefix, "/") && rel != "" && !strings.HasPrefix(rel, "/") {
+		abortWithError(c, http.StatusNotFound)
+		return
+	}
+	name, err := h.resolve(rel)
+	if err != nil {
+		abortWithError(c, http.StatusNotFound)
+		return
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		abortWithError(c, http.StatusNotFound)
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(urlPath, "/") {
+			target := urlPath + "/"
+			if c.Request.URL.RawQuery != "" {
+				target += "?" + c.Request.URL.RawQuery
+			}
+			c.Redirect(http.StatusMovedPermanently, target)
+			return
+		}
+
+		if index := h.findIndex(rel); index != "" {
+			h.serveFile(c, index, path.Join(urlPath, filepath.Base(index)))
+			return
+		}
+
+		if h.autoindex.Enable {
+			h.listDirectory(c, name)
+			return
+		}
+
+		abortWithError(c, http.StatusForbidden)
+		return
+	}
+
+	h.serveFile(c, name, urlPath)
+}
+
+/*
+* findPrecompressed returns the path and coding of the precompressed sibling of the file name which
+* the client accepts. "" is returned if there is not
+ */
+func (h *staticHandler) findPrecompressed(c *gin.Context, name string) (string, string) {
+	for _, encoding := range orderedEncodings(c.Request.Header.Get("Accept-Encoding"), precompressedEncodings()) {
+		if sibling := h.sibling(name, encoding); sibling != "" {
+			return sibling, encoding
+		}
+	}
+
+	return "", ""
+}
+
+//sibling returns the path of the precompressed sibling of name in encoding. "" is returned if there is not
+func (h *staticHandler) sibling(name string, encoding string) string {
+	for _, p := range precompressedExts {
+		if p.encoding != encoding {
+			continue
+		}
+		sibling, err := h.confine(name + p.ext)
+		if err != nil {
+			return ""
+		}
+		if info, err := os.Stat(sibling); err == nil && info.Mode().IsRegular() {
+			return sibling
+		}
+	}
+
+	return ""
+}
+
+/*
+* serveFile sends the content of the file name. Range, If-Range, If-None-Match and If-Modified-Since
+* are handled by http.ServeContent with the ETag of the file. The content is sent by sendfile if
+* the connection supports it. The precompressed sibling is sent instead if the client accepts it.
+* the file is sent from the cache if it is enabled. The Cache-Control rules are applied by urlPath
+ */
+func (h *staticHandler) serveFile(c *gin.Context, name string, urlPath string) {
+	if h.cache != nil {
+		if e := h.cache.get(name); e != nil {
+			h.serveCached(c, e, urlPath)
+			return
+		}
+	}
+
+	if h.compression.Precompressed {
+		addVary(c.Writer.Header(), "Accept-Encoding")
+		if sibling, encoding := h.findPrecompressed(c, name); sibling != "" {
+			c.Header("Content-Encoding", encoding)
+			//the type is got from the original file, or it would be the type of the sibling such as application/gzip
+			c.Header("Content-Type", contentType(name))
+			name = sibling
+		}
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		abortWithError(c, http.StatusForbidden)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		abortWithError(c, http.StatusForbidden)
+		return
+	}
+
+	c.Header("ETag", fileETag(info))
+	applyCacheRules(c.Writer.Header(), h.cacheRules, urlPath)
+	w := newSendfileWriter(c)
+	http.ServeContent(w, c.Request, info.Name(), info.ModTime(), f)
+	if w.sent > 0 {
+		c.Set(sentBytesKey, w.sent)
+	}
+}
+
+/*
+* serveCached sends the file in the cache. The compressed variant is sent if the client accepts it.
+* the variants are got from the precompressed siblings or by compressing the file, and then are cached too
+ */
+func (h *staticHandler) serveCached(c *gin.Context, e *cacheEntry, urlPath string) {
+	var offered []string
+	if h.compression.Precompressed {
+		offered = precompressedEncodings()
+	}
+	onTheFly := h.compression.Enable && len(e.data) >= h.compression.MinSize && compressibleType(e.ctype, h.compression.Types)
+	if onTheFly {
+		offered = append(offered, h.compression.Encodings...)
+	}
+
+	data, etag := e.data, e.etag
+	if len(offered) > 0 {
+		addVary(c.Writer.Header(), "Accept-Encoding")
+	}
+	for _, encoding := range orderedEncodings(c.Request.Header.Get("Accept-Encoding"), offered) {
+		var sibling string
+		key := encoding
+		if h.compression.Precompressed {
+			sibling = h.sibling(e.name, encoding)
+			//the changes of the sibling are not watched when invalidation is mtime
+			if info, err := os.Stat(sibling); sibling != "" && err == nil && h.cache.watcher == nil {
+				key += "@" + fileETag(info)
+			}
+		}
+		variant := h.cache.variant(e, encoding, key, func() []byte {
+			if sibling != "" {
+				data, _ := ioutil.ReadFile(sibling)
+				return data
+			}
+			if onTheFly {
+				return compressBytes(e.data, encoding, h.compression.Level)
+			}
+			return nil
+		})
+		if variant != nil {
+			data = variant
+			etag = strings.TrimSuffix(e.etag, "\"") + "-" + encoding + "\""
+			c.Header("Content-Encoding", encoding)
+			break
+		}
+	}
+
+	c.Header("ETag", etag)
+	c.Header("Content-Type", e.ctype)
+	applyCacheRules(c.Writer.Header(), h.cacheRules, urlPath)
+	http.ServeContent(c.Writer, c.Request, filepath.Base(e.name), e.modTime, bytes.NewReader(data))
+}
+
+//contentType returns the MIME type of the file name by its extension. The content is sniffed if the extension is unknown
+func contentType(name string) string {
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		return ctype
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(f, buf)
+
+	return http.DetectContentType(buf[:n])
+}
+
+//fileETag returns the strong ETag of a file from its modification time and size
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+}
+
+//The key of the number of bytes sent by sendfile in gin.Context, which are not counted by gin
+const sentBytesKey = "sysadm.sentBytes"
+
+//The key of the http.ResponseWriter of net/http in the context of a request
+type responseWriterKey struct{}
+
+/*
+* withResponseWriter puts the http.ResponseWriter of net/http into the context of every request, so the static
+* handler can use its ReadFrom, which uses sendfile, instead of copying the file through gin.ResponseWriter
+ */
+func withResponseWriter(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), responseWriterKey{}, w)))
+	})
+}
+
+//Struct for a gin.ResponseWriter which sends files by the ReadFrom of the http.ResponseWriter of net/http
+type sendfileWriter struct {
+	gin.ResponseWriter
+	rf   io.ReaderFrom //nil if the http.ResponseWriter of net/http does not implement io.ReaderFrom
+	sent int64
+}
+
+func newSendfileWriter(c *gin.Context) *sendfileWriter {
+	w := &sendfileWriter{ResponseWriter: c.Writer}
+	w.rf, _ = c.Request.Context().Value(responseWriterKey{}).(io.ReaderFrom)
+
+	return w
+}
+
+//ReadFrom is used by io.Copy in http.ServeContent
+func (w *sendfileWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	if w.rf == nil {
+		return io.Copy(w.ResponseWriter, onlyReader{r})
+	}
+
+	w.ResponseWriter.WriteHeaderNow()
+	if t, ok := w.ResponseWriter.(interface{ transformsBody() bool }); ok && t.transformsBody() {
+		return io.Copy(w.ResponseWriter, onlyReader{r})
+	}
+
+	n, err = w.rf.ReadFrom(r)
+	w.sent += n
+
+	return n, err
+}
+
+//onlyReader hides the WriterTo of a reader, so io.Copy does not loop back to ReadFrom
+type onlyReader struct {
+	io.Reader
+}
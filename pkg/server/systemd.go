@@ -0,0 +1,155 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 29 2021
+**/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//The first fd passed by systemd socket activation
+const listenFDsStart = 3
+
+//States sent to systemd by sdNotify
+const (
+	sdReady     = "READY=1"
+	sdReloading = "RELOADING=1"
+	sdStopping  = "STOPPING=1"
+	sdWatchdog  = "WATCHDOG=1"
+)
+
+/*
+* activatedListeners returns the listeners passed by systemd through LISTEN_FDS and LISTEN_FDNAMES.
+* the key of the map is the name of the socket, which is set by FileDescriptorName= in the socket unit.
+* An empty map is returned if the process is not started by socket activation
+ */
+func activatedListeners() (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener)
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return listeners, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil {
+		return listeners, fmt.Errorf("invalid LISTEN_FDS: %s", err)
+	}
+
+	return listenFDs(listenFDsStart, n, names)
+}
+
+//listenFDs creates the listeners from n fds starting at start. names are the names of the fds in order
+func listenFDs(start int, n int, names []string) (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener)
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(start+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return listeners, fmt.Errorf("use the socket %s passed by systemd error: %s", name, err)
+		}
+		if _, found := listeners[name]; found || name == "" {
+			l.Close()
+			return listeners, fmt.Errorf("the name:%q of the socket passed by systemd is empty or duplicated", name)
+		}
+		listeners[name] = l
+	}
+
+	return listeners, nil
+}
+
+/*
+* sdNotify sends state to systemd through NOTIFY_SOCKET. Nothing is sent if the process is not
+* started by a unit of Type=notify
+ */
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		//abstract socket
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+
+	return err
+}
+
+//watchdogInterval returns the interval of sending WATCHDOG=1, which is half of WATCHDOG_USEC. 0 is returned if the watchdog is disabled
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+//watchdog sends WATCHDOG=1 to systemd periodically until the server is shutting down
+func (svr *Server) watchdog() {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-svr.icontext.Done():
+			return
+		case <-ticker.C:
+			if err := sdNotify(sdWatchdog); err != nil {
+				svr.logger.LoggingLogf("error", "warn", "Notify systemd error:%s", err)
+			}
+		}
+	}
+}
+
+//notifySystemd sends state to systemd and logs the error if it fails
+func (svr *Server) notifySystemd(state string) {
+	if err := sdNotify(state); err != nil {
+		svr.logger.LoggingLogf("error", "warn", "Notify systemd %s error:%s", state, err)
+	}
+}
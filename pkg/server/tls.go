@@ -0,0 +1,337 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 25 2021
+**/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wangyysde/bzhyserver/pkg/config"
+)
+
+//The interval for checking whether the certificate files have been changed
+const certCheckInterval = 5 * time.Second
+
+/*
+* certStore holds the certificates of a listener and selects one by the server name of SNI.
+* The certificates can be reloaded without restarting the server
+ */
+type certStore struct {
+	mu sync.RWMutex
+
+	files   []config.Certificate
+	certs   []*tls.Certificate          //certs[0] is the default certificate
+	byName  map[string]*tls.Certificate //key is a DNS name or a wildcard name such as *.example.com
+	modTime time.Time                   //the latest modification time of the files when loading
+
+	clientCA  string          //the path of the CA bundle for verifying client certificates
+	crlFile   string          //the path of the CRL of client certificates
+	clientCAs *x509.CertPool  //nil if clientCA is empty
+	crlIssuer []byte          //the subject of the CA which issued the CRL. nil if crlFile is empty
+	revoked   map[string]bool //the serial numbers in the CRL
+}
+
+func newCertStore(files []config.Certificate, clientCA string, crlFile string) (*certStore, error) {
+	s := &certStore{files: files, clientCA: clientCA, crlFile: crlFile}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+/*
+* load reads all of the certificates and builds the index of names.
+* the certificates in use are not changed if any of them can not be loaded
+ */
+func (s *certStore) load() error {
+	var certs []*tls.Certificate
+	byName := make(map[string]*tls.Certificate)
+	modTime := s.latestModTime()
+
+	for _, f := range s.files {
+		cert, err := config.LoadKeyPair(f)
+		if err != nil {
+			return err
+		}
+		if cert.Leaf == nil {
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return fmt.Errorf("parse certificate %s error: %s", f.Name(), err)
+			}
+		}
+
+		c := &cert
+		certs = append(certs, c)
+		names := cert.Leaf.DNSNames
+		if cert.Leaf.Subject.CommonName != "" {
+			names = append(names, cert.Leaf.Subject.CommonName)
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, found := byName[name]; !found {
+				byName[name] = c
+			}
+		}
+	}
+
+	var clientCAs *x509.CertPool
+	var crlIssuer []byte
+	revoked := make(map[string]bool)
+	if s.clientCA != "" {
+		cas, err := config.LoadClientCA(s.clientCA)
+		if err != nil {
+			return fmt.Errorf("load client CA %s error: %s", s.clientCA, err)
+		}
+		clientCAs = x509.NewCertPool()
+		for _, ca := range cas {
+			clientCAs.AddCert(ca)
+		}
+
+		if s.crlFile != "" {
+			crl, issuer, err := config.LoadCRL(s.crlFile, cas)
+			if err != nil {
+				return fmt.Errorf("load CRL %s error: %s", s.crlFile, err)
+			}
+			for _, entry := range crl.TBSCertList.RevokedCertificates {
+				revoked[entry.SerialNumber.String()] = true
+			}
+			crlIssuer = issuer.RawSubject
+		}
+	}
+
+	s.mu.Lock()
+	s.certs = certs
+	s.byName = byName
+	s.modTime = modTime
+	s.clientCAs = clientCAs
+	s.crlIssuer = crlIssuer
+	s.revoked = revoked
+	s.mu.Unlock()
+
+	return nil
+}
+
+/*
+* latestModTime returns the latest modification time of the certificate, key, client CA and CRL files.
+* the certificates and keys given in PEM are skipped, they are reloaded only with the config
+ */
+func (s *certStore) latestModTime() (latest time.Time) {
+	files := []config.Certificate{{CertFile: s.clientCA, KeyFile: s.crlFile}}
+	for _, f := range append(files, s.files...) {
+		for _, file := range []string{f.CertFile, f.KeyFile} {
+			if file == "" || config.IsPEM(file) {
+				continue
+			}
+			if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+				latest = info.ModTime()
+			}
+		}
+	}
+
+	return latest
+}
+
+/*
+* unreadable returns the files of the store which can not be read by the process, such as the keys
+* which only root can read after dropping privileges
+ */
+func (s *certStore) unreadable() (files []string) {
+	all := []config.Certificate{{CertFile: s.clientCA, KeyFile: s.crlFile}}
+	for _, f := range append(all, s.files...) {
+		for _, file := range []string{f.CertFile, f.KeyFile} {
+			if file == "" || config.IsPEM(file) {
+				continue
+			}
+			fp, err := os.Open(file)
+			if err != nil {
+				files = append(files, file)
+				continue
+			}
+			fp.Close()
+		}
+	}
+
+	return files
+}
+
+//changed returns true if any of the files has been modified since last loading
+func (s *certStore) changed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.latestModTime().After(s.modTime)
+}
+
+/*
+* getCertificate selects the certificate by the server name of SNI. The exact name is
+* tried first and then the wildcard name. The default certificate is returned if none matches
+ */
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, found := s.byName[name]; found {
+		return cert, nil
+	}
+
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, found := s.byName["*"+name[i:]]; found {
+			return cert, nil
+		}
+	}
+
+	return s.certs[0], nil
+}
+
+/*
+* watchCerts reloads the certificates of sl when the files have been changed until the server is shutting down.
+* errors are logged and the certificates in use are kept. The files are read as the user which the server
+* runs as after dropping privileges, so the files which only root can read can not be reloaded
+ */
+func (svr *Server) watchCerts(sl *serverListener) {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-svr.icontext.Done():
+			return
+		case <-ticker.C:
+			if !sl.certs.changed() {
+				continue
+			}
+			if err := sl.certs.load(); err != nil {
+				svr.logger.LoggingLogf("error", "error", "Reload certificates of listener %s error:%s", sl.cfg.Name, err)
+			} else {
+				svr.logger.LoggingLogf("error", "info", "Certificates of listener %s have been reloaded", sl.cfg.Name)
+			}
+		}
+	}
+}
+
+//newTLSConfig creates the tls.Config for the listener cfg which certificates are in store
+func newTLSConfig(cfg config.Listener, store *certStore) (*tls.Config, error) {
+	minVersion, err := config.TLSVersion(cfg.TLS.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	cipherSuites, err := config.CipherSuiteIDs(cfg.TLS.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	nextProtos := cfg.TLS.ALPN
+	if len(nextProtos) == 0 {
+		for _, proto := range []string{config.ProtoHTTP2, config.ProtoHTTP1} {
+			if cfg.HasProtocol(proto) {
+				nextProtos = append(nextProtos, proto)
+			}
+		}
+	}
+
+	clientAuth, err := config.ClientAuthType(cfg.TLS.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: store.getCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		NextProtos:     nextProtos,
+		ClientAuth:     clientAuth,
+	}
+	if cfg.TLS.ClientCA != "" {
+		tlsConfig.VerifyPeerCertificate = store.verifyPeerCertificate
+		//the client CAs may be reloaded, so they are set for every handshake
+		tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c := tlsConfig.Clone()
+			c.GetConfigForClient = nil
+			c.ClientCAs = store.clientPool()
+			return c, nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+/*
+* checkCertsReadable logs an error for every certificate or key which can not be read after dropping
+* privileges, because it can not be reloaded by watchCerts or SIGHUP until the server is restarted
+ */
+func (svr *Server) checkCertsReadable() {
+	for _, sl := range svr.listeners {
+		if sl.certs == nil {
+			continue
+		}
+		for _, file := range sl.certs.unreadable() {
+			svr.logger.LoggingLogf("error", "error", "%s of listener %s is not readable after dropping privileges, it can not be reloaded", file, sl.cfg.Name)
+		}
+	}
+}
+
+/*
+* reloadCerts reloads the certificates of all TLS listeners. It is called when SIGHUP is received.
+* the certificates in use are kept if any of them can not be loaded
+ */
+func (svr *Server) reloadCerts() {
+	for _, sl := range svr.listeners {
+		if sl.certs == nil {
+			continue
+		}
+		if err := sl.certs.load(); err != nil {
+			svr.logger.LoggingLogf("error", "error", "Reload certificates of listener %s error:%s", sl.cfg.Name, err)
+			continue
+		}
+		svr.logger.LoggingLogf("error", "info", "Certificates of listener %s have been reloaded", sl.cfg.Name)
+	}
+}
+
+/*
+* redirectHandler redirects all requests to https on port with the same host and URI.
+* the port is omitted from the URL if it is 443
+ */
+func redirectHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		//an IPv6 address without port is still in brackets
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
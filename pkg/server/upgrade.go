@@ -0,0 +1,240 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified Apr 28 2021
+**/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	upgradeListenersEnv = "_SYSADM_UPGRADE_LISTENERS" //the listeners passed to the new process in JSON
+	upgradeReadyEnv     = "_SYSADM_UPGRADE_READY"     //the fd which the new process notifies the old one it is ready through
+	upgradeTimeout      = 30 * time.Second            //the old process keeps serving if the new one is not ready in it
+)
+
+//Struct for a listening socket passed to the new process when upgrading
+type inheritedFD struct {
+	Name string `json:"name"` //the name of the listener
+	FD   int    `json:"fd"`
+}
+
+//listenerFile returns a duplicate of the file descriptor of l
+func listenerFile(l net.Listener) (*os.File, error) {
+	fl, ok := l.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("can not get the file of listener on %s", l.Addr())
+	}
+
+	return fl.File()
+}
+
+//filterEnv returns env without the variables which names are in names
+func filterEnv(env []string, names ...string) []string {
+	var ret []string
+	for _, e := range env {
+		keep := true
+		for _, name := range names {
+			if strings.HasPrefix(e, name+"=") {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			ret = append(ret, e)
+		}
+	}
+
+	return ret
+}
+
+/*
+* startUpgrade starts the executable of the program, which may have been replaced by a new version,
+* with the same arguments and passes the listening sockets to it. It waits until the new process
+* notifies it is ready. The new process is killed if it is not ready in upgradeTimeout
+ */
+func (svr *Server) startUpgrade() (pid int, err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var fds []inheritedFD
+	for _, sl := range svr.listeners {
+		f, err := listenerFile(sl.listener)
+		if err != nil {
+			return 0, err
+		}
+		//the fds of cmd.ExtraFiles start at 3 in the new process
+		fds = append(fds, inheritedFD{Name: sl.cfg.Name, FD: 3 + len(files)})
+		files = append(files, f)
+	}
+	data, err := json.Marshal(fds)
+	if err != nil {
+		return 0, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	//the new process becomes the main process of systemd unit after it is ready, so WATCHDOG_PID is removed
+	cmd.Env = append(filterEnv(os.Environ(), upgradeListenersEnv, upgradeReadyEnv, "WATCHDOG_PID"),
+		upgradeListenersEnv+"="+string(data), upgradeReadyEnv+"="+strconv.Itoa(3+len(files)))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+		if err == nil {
+			return cmd.Process.Pid, nil
+		}
+		return 0, fmt.Errorf("the new process %d closed the notification pipe before ready", cmd.Process.Pid)
+	case err = <-exited:
+		return 0, fmt.Errorf("the new process %d exited before ready: %v", cmd.Process.Pid, err)
+	case <-time.After(upgradeTimeout):
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("the new process %d was not ready in %s", cmd.Process.Pid, upgradeTimeout)
+	}
+}
+
+/*
+* upgrade is called when SIGUSR2 is received. It returns true if the new process is serving on the listeners,
+* then the server should be shut down. The pid file written by the new process is kept.
+* the server keeps serving and rewrites the pid file if the upgrading fails
+ */
+func (svr *Server) upgrade() (pid int, ok bool) {
+	svr.logger.LoggingLogf("error", "info", "Upgrading the server")
+
+	pid, err := svr.startUpgrade()
+	if err != nil {
+		svr.logger.LoggingLogf("error", "error", "Upgrade the server error:%s", err)
+		svr.writePidFile()
+		return 0, false
+	}
+
+	for _, sl := range svr.listeners {
+		//the socket file is being used by the new process
+		if ul, isUnix := sl.listener.(*net.UnixListener); isUnix {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	svr.pidFile = ""
+	svr.upgraded = true
+	svr.logger.LoggingLogf("error", "info", "The new process %d is ready", pid)
+
+	return pid, true
+}
+
+/*
+* inheritedListeners returns the listeners passed by the old process when upgrading. The key of the map
+* is the name of the listener. An empty map is returned if the process is not started by upgrading
+ */
+func inheritedListeners() (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener)
+	data := os.Getenv(upgradeListenersEnv)
+	if data == "" {
+		return listeners, nil
+	}
+	os.Unsetenv(upgradeListenersEnv)
+
+	var fds []inheritedFD
+	if err := json.Unmarshal([]byte(data), &fds); err != nil {
+		return listeners, fmt.Errorf("parse %s error: %s", upgradeListenersEnv, err)
+	}
+
+	for _, fd := range fds {
+		f := os.NewFile(uintptr(fd.FD), fd.Name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return listeners, fmt.Errorf("inherit listener %s error: %s", fd.Name, err)
+		}
+		//the socket file is removed when the listener is closed as the one bound by the process
+		if ul, isUnix := l.(*net.UnixListener); isUnix {
+			ul.SetUnlinkOnClose(true)
+		}
+		listeners[fd.Name] = l
+	}
+
+	return listeners, nil
+}
+
+//notifyUpgradeReady notifies the old process that the new process is serving if it is started by upgrading
+func notifyUpgradeReady() error {
+	return notifyReadyFD(upgradeReadyEnv)
+}
+
+/*
+* notifyReadyFD writes a byte to the fd in the environment variable env, which is the pipe the parent
+* process is waiting on. Nothing is done if env is not set
+ */
+func notifyReadyFD(env string) error {
+	data := os.Getenv(env)
+	if data == "" {
+		return nil
+	}
+	os.Unsetenv(env)
+
+	fd, err := strconv.Atoi(data)
+	if err != nil {
+		return errors.New("invalid fd in " + env + ": " + data)
+	}
+
+	f := os.NewFile(uintptr(fd), env)
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+
+	return err
+}
@@ -0,0 +1,265 @@
+/**
+* SYSADM Server
+* @Author  Wayne Wang <net_use@bzhy.com>
+* @Copyright Bzhy Network
+* @HomePage http://www.sysadm.cn
+* @Version 0.21.03
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+* http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*	@License GNU Lesser General Public License  https://www.sysadm.cn/lgpl.html
+* @Modified May 06 2021
+**/
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wangyysde/bzhyserver/pkg/config"
+	"github.com/wangyysde/bzhyserver/pkg/logger"
+)
+
+//Struct for a site served by the server. It is the server block itself or a virtual host
+type vhost struct {
+	cfg       config.VHost
+	engine    *gin.Engine
+	logger    *logger.SysadmLogger
+	ownLogger bool //logger has been opened for the virtual host, so it should be closed by it
+}
+
+//Struct for a wildcard server name
+type wildcardName struct {
+	affix string //the suffix such as .example.com of *.example.com, or the prefix such as www. of www.example.*
+	vh    *vhost
+}
+
+/*
+* vhostRouter dispatches the requests to the gin engines of virtual hosts by Host or SNI.
+* the exact name is matched first, then the longest name with a leading wildcard, and then
+* the longest name with a trailing wildcard. The default site serves the requests matching none
+ */
+type vhostRouter struct {
+	exact    map[string]*vhost
+	leading  []wildcardName
+	trailing []wildcardName
+	def      *vhost
+}
+
+func newVHostRouter(sites []*vhost, def *vhost) *vhostRouter {
+	vr := &vhostRouter{exact: make(map[string]*vhost), def: def}
+	for _, vh := range sites {
+		for _, name := range vh.cfg.ServerNames {
+			switch {
+			case strings.HasPrefix(name, "*."):
+				vr.leading = append(vr.leading, wildcardName{affix: name[1:], vh: vh})
+			case strings.HasSuffix(name, ".*"):
+				vr.trailing = append(vr.trailing, wildcardName{affix: name[:len(name)-1], vh: vh})
+			default:
+				vr.exact[name] = vh
+			}
+		}
+	}
+
+	for _, names := range [][]wildcardName{vr.leading, vr.trailing} {
+		sort.SliceStable(names, func(i, j int) bool {
+			return len(names[i].affix) > len(names[j].affix)
+		})
+	}
+
+	return vr
+}
+
+//match returns the site for host
+func (vr *vhostRouter) match(host string) *vhost {
+	if vh, found := vr.exact[host]; found {
+		return vh
+	}
+
+	for _, w := range vr.leading {
+		if strings.HasSuffix(host, w.affix) {
+			return w.vh
+		}
+	}
+
+	for _, w := range vr.trailing {
+		if strings.HasPrefix(host, w.affix) {
+			return w.vh
+		}
+	}
+
+	return vr.def
+}
+
+func (vr *vhostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vr.match(requestHost(r)).engine.ServeHTTP(w, r)
+}
+
+//requestHost returns the host of r in lower case without port. The server name of SNI is used if there is not Host
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if host == "" && r.TLS != nil {
+		host = r.TLS.ServerName
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+/*
+* newVHost creates the site for the virtual host cfg. Its own log files are opened if they are set,
+* and the log files in log block are used for the ones not set
+ */
+func (svr *Server) newVHost(cfg config.VHost) (*vhost, error) {
+	vh := &vhost{cfg: cfg, logger: svr.logger}
+	if cfg.AccessLog == "" && cfg.ErrorLog == "" {
+		return vh, nil
+	}
+
+	accessLog, errorLog := cfg.AccessLog, cfg.ErrorLog
+	if accessLog == "" {
+		accessLog = svr.settings.Logger.AccessLog
+	}
+	if errorLog == "" {
+		errorLog = svr.settings.Logger.ErrorLog
+	}
+
+	l := logger.NewInstance()
+	if _, err := l.OpenLogfile("access", accessLog); err != nil {
+		return nil, err
+	}
+	if _, err := l.OpenLogfile("error", errorLog); err != nil {
+		l.EndLogger("access")
+		return nil, err
+	}
+	vh.logger, vh.ownLogger = l, true
+
+	return vh, nil
+}
+
+/*
+* newEngine builds the gin engine of the site vh with the error pages of the server.
+* Error no: AABBB. AA: file seq,main is 1; BBB: error no
+ */
+func (svr *Server) newEngine(vh *vhost, pages *errorPages) (ret int) {
+	settings := svr.settings.Server
+	rw, err := newRewriter(settings.Rewrite, vh.logger)
+	if err != nil {
+		svr.logger.LoggingLogf("error", "fatal", "Create rewrite rules error:%s", err)
+		return 10019
+	}
+
+	r := gin.New()
+	r.HandleMethodNotAllowed = true
+	//the middlewares before the rewriter are run again for the rewritten requests
+	r.Use(accessLogger(vh.logger), pages.handler(vh.logger), recovery(vh.logger), clientAuth(), rw.handler(r), compress(&settings.Compression))
+
+	static := newStaticHandler(vh.cfg.RootPath, vh.cfg.Index, settings.Autoindex, &settings.Compression, svr.cache, settings.CacheControl)
+	locs, err := svr.newLocations(vh, static.handle)
+	if err != nil {
+		svr.logger.LoggingLogf("error", "fatal", "Create locations error:%s", err)
+		return 10018
+	}
+	r.NoRoute(locs.handle)
+	r.NoMethod(func(c *gin.Context) {
+		abortWithError(c, http.StatusMethodNotAllowed)
+	})
+	vh.engine = r
+
+	return 0
+}
+
+/*
+* initSites builds the site of server block and the virtual hosts. The router dispatching the requests
+* by Host or SNI is created if there is any virtual host. The admin API is served by the default site.
+* Error no: AABBB. AA: file seq,main is 1; BBB: error no
+ */
+func (svr *Server) initSites(pages *errorPages) (ret int) {
+	settings := svr.settings.Server
+	def := &vhost{
+		cfg:    config.VHost{RootPath: settings.RootPath, Index: svr.nindexs, Locations: settings.Locations},
+		logger: svr.logger,
+	}
+	if ret = svr.newEngine(def, pages); ret > 0 {
+		return ret
+	}
+	svr.r = def.engine
+	svr.sites = []*vhost{def}
+
+	for _, cfg := range settings.VHosts {
+		vh, err := svr.newVHost(cfg)
+		if err != nil {
+			svr.logger.LoggingLogf("error", "fatal", "Open the log files of virtual host %v error:%s", cfg.ServerNames, err)
+			return 10020
+		}
+		svr.sites = append(svr.sites, vh)
+		if ret = svr.newEngine(vh, pages); ret > 0 {
+			return ret
+		}
+		if cfg.Default {
+			def = vh
+		}
+	}
+
+	registerAdminRoutes(def.engine)
+	if len(settings.VHosts) > 0 {
+		svr.vhosts = newVHostRouter(svr.sites[1:], def)
+	}
+
+	return 0
+}
+
+//handler returns the handler of all requests. It is the router of virtual hosts if there is any
+func (svr *Server) handler() http.Handler {
+	if svr.vhosts != nil {
+		return svr.vhosts
+	}
+
+	return svr.r
+}
+
+//vhostCertificates returns the certificates of the virtual hosts which are selected by SNI on all TLS listeners
+func (svr *Server) vhostCertificates() []config.Certificate {
+	var certs []config.Certificate
+	for _, v := range svr.settings.Server.VHosts {
+		if v.TLS.CertFile != "" {
+			certs = append(certs, v.TLS)
+		}
+	}
+
+	return certs
+}
+
+//vhostLogFiles returns the log files of the virtual hosts
+func (svr *Server) vhostLogFiles() []string {
+	var files []string
+	for _, v := range svr.settings.Server.VHosts {
+		files = append(files, v.AccessLog, v.ErrorLog)
+	}
+
+	return files
+}
+
+//closeVHostLogs closes the log files opened for the virtual hosts
+func (svr *Server) closeVHostLogs() {
+	for _, vh := range svr.sites {
+		if vh.ownLogger {
+			vh.logger.EndLogger("access")
+			vh.logger.EndLogger("error")
+			vh.ownLogger = false
+		}
+	}
+}